@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// benchRequests are the timed requests, {id} is replaced by a random account id
+var benchRequests = []struct {
+	name   string
+	target string
+}{
+	{"filter", "/accounts/filter/?sex_eq=f&status_neq=" + url.QueryEscape("заняты") + "&limit=20"},
+	{"group", "/accounts/group/?keys=city&order=-1&limit=10"},
+	{"recommend", "/accounts/{id}/recommend/?limit=10"},
+	{"suggest", "/accounts/{id}/suggest/?limit=10"},
+	{"matches", "/accounts/{id}/matches/?limit=10"},
+}
+
+// bench times the requests against the stored accounts without listening,
+// the indexes are built from the database first
+func bench(opts opts, args []string) {
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	n := flags.Int("n", 1000, "requests of every kind")
+	seed := flags.Int64("seed", 1, "seed of the random account ids")
+	_ = flags.Parse(args)
+	if *n <= 0 {
+		log.Fatal("[ERROR] -n must be positive")
+	}
+
+	app := newApp(opts, false)
+	err := app.IndexCollection()
+	if err != nil {
+		log.Fatal("[ERROR] ", err)
+	}
+	ids := app.AccountIDs()
+	if len(ids) == 0 {
+		log.Fatal("[ERROR] no accounts stored")
+	}
+	sort.Ints(ids)
+	random := rand.New(rand.NewSource(*seed))
+	handler := app.Handler()
+
+	fmt.Printf("%-10s %8s %8s %10s %10s %10s %10s\n", "request", "n", "errors", "avg", "p50", "p99", "max")
+	for _, request := range benchRequests {
+		durations := make([]time.Duration, 0, *n)
+		errors := 0
+		var total time.Duration
+		for i := 0; i < *n; i++ {
+			id := strconv.Itoa(ids[random.Intn(len(ids))])
+			target := strings.Replace(request.target, "{id}", id, 1)
+
+			response := httptest.NewRecorder()
+			start := time.Now()
+			handler.ServeHTTP(response, httptest.NewRequest(http.MethodGet, target, nil))
+			elapsed := time.Since(start)
+
+			if response.Code != http.StatusOK {
+				errors++
+			}
+			durations = append(durations, elapsed)
+			total += elapsed
+		}
+
+		sort.Slice(durations, func(i, j int) bool {
+			return durations[i] < durations[j]
+		})
+		fmt.Printf("%-10s %8d %8d %10v %10v %10v %10v\n", request.name, *n, errors,
+			total/time.Duration(*n), durations[len(durations)/2], durations[len(durations)*99/100], durations[len(durations)-1])
+	}
+}
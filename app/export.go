@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"hlc/app/rest"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// export writes the stored accounts matching the filter predicates
+// given as key=value arguments, e.g. hlc export -format zip -out a.zip sex_eq=f
+func export(opts opts, args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String("format", rest.FormatNDJSON, "ndjson or zip")
+	out := flags.String("out", "", "output file, stdout by default")
+	_ = flags.Parse(args)
+
+	params := url.Values{}
+	for _, arg := range flags.Args() {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 {
+			log.Fatal("[ERROR] predicate must be key=value: ", arg)
+		}
+		params.Add(kv[0], kv[1])
+	}
+
+	app := newApp(opts, false)
+
+	query, err := app.ExportQuery(params)
+	if err != nil {
+		log.Fatal("[ERROR] ", err)
+	}
+
+	w := os.Stdout
+	if *out != "" {
+		w, err = os.Create(*out)
+		if err != nil {
+			log.Fatal("[ERROR] ", err)
+		}
+	}
+	err = app.Export(w, *format, query)
+	if err != nil {
+		log.Fatal("[ERROR] ", err)
+	}
+	err = w.Close()
+	if err != nil {
+		log.Fatal("[ERROR] ", err)
+	}
+}
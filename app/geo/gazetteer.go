@@ -0,0 +1,85 @@
+package geo
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const earthRadiusKm = 6371.0
+
+type City struct {
+	Name string
+	Lat  float64
+	Lon  float64
+}
+
+// Gazetteer maps city names to coordinates and finds the cities around a city
+type Gazetteer struct {
+	cities map[string]City
+	tree   *kdTree
+}
+
+// LoadGazetteer reads "name,lat,lon" CSV records, a header line is skipped
+func LoadGazetteer(r io.Reader) (*Gazetteer, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 3
+	reader.TrimLeadingSpace = true
+
+	g := &Gazetteer{cities: make(map[string]City)}
+	points := make([]point, 0)
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		lat, errLat := strconv.ParseFloat(strings.TrimSpace(record[1]), 64)
+		lon, errLon := strconv.ParseFloat(strings.TrimSpace(record[2]), 64)
+		if errLat != nil || errLon != nil {
+			if line == 1 {
+				continue
+			}
+			return nil, errors.New("bad coordinates in line " + strconv.Itoa(line))
+		}
+
+		city := City{Name: record[0], Lat: lat, Lon: lon}
+		if _, ok := g.cities[city.Name]; ok {
+			continue
+		}
+		g.cities[city.Name] = city
+		points = append(points, newPoint(city))
+	}
+
+	g.tree = newKdTree(points)
+	return g, nil
+}
+
+func (g *Gazetteer) Len() int {
+	return len(g.cities)
+}
+
+// Within returns the names of the cities not farther than km from the city,
+// the city itself included. The result is false for an unknown city.
+func (g *Gazetteer) Within(name string, km float64) ([]string, bool) {
+	city, ok := g.cities[name]
+	if !ok {
+		return nil, false
+	}
+
+	//the great circle distance is compared as the chord length between unit vectors
+	angle := math.Min(km/earthRadiusKm, math.Pi)
+	chord := 2 * math.Sin(angle/2)
+
+	names := make([]string, 0)
+	g.tree.within(newPoint(city), chord*chord, func(p point) {
+		names = append(names, p.name)
+	})
+	return names, true
+}
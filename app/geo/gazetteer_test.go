@@ -0,0 +1,149 @@
+package geo
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// haversine is the great circle distance in km
+func haversine(a, b City) float64 {
+	lat1, lat2 := a.Lat*math.Pi/180, b.Lat*math.Pi/180
+	dLat := lat2 - lat1
+	dLon := (b.Lon - a.Lon) * math.Pi / 180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// bruteWithin is Within computed over all the cities
+func bruteWithin(cities []City, center City, km float64) []string {
+	names := make([]string, 0)
+	for _, city := range cities {
+		if haversine(center, city) <= km {
+			names = append(names, city.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func loadCities(t *testing.T, cities []City) *Gazetteer {
+	var csv bytes.Buffer
+	fmt.Fprintln(&csv, "name,lat,lon")
+	for _, city := range cities {
+		fmt.Fprintf(&csv, "%s,%v,%v\n", city.Name, city.Lat, city.Lon)
+	}
+	g, err := LoadGazetteer(&csv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func within(t *testing.T, g *Gazetteer, name string, km float64) []string {
+	names, ok := g.Within(name, km)
+	if !ok {
+		t.Fatalf("%s is unknown", name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestWithinMatchesHaversine(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	cities := make([]City, 0)
+	for i := 0; i < 300; i++ {
+		cities = append(cities, City{Name: fmt.Sprint("c", i), Lat: random.Float64()*180 - 90, Lon: random.Float64()*360 - 180})
+	}
+	//clusters around the antimeridian and the poles
+	for i := 0; i < 50; i++ {
+		cities = append(cities,
+			City{Name: fmt.Sprint("east", i), Lat: random.Float64()*20 - 10, Lon: 180 - random.Float64()*3},
+			City{Name: fmt.Sprint("west", i), Lat: random.Float64()*20 - 10, Lon: -180 + random.Float64()*3},
+			City{Name: fmt.Sprint("north", i), Lat: 90 - random.Float64()*3, Lon: random.Float64()*360 - 180},
+			City{Name: fmt.Sprint("south", i), Lat: -90 + random.Float64()*3, Lon: random.Float64()*360 - 180},
+		)
+	}
+	cities = append(cities, City{Name: "north pole", Lat: 90}, City{Name: "south pole", Lat: -90})
+	g := loadCities(t, cities)
+
+	for _, km := range []float64{0, 1, 100, 500, 2000, 10000, 20100} {
+		for _, center := range cities {
+			got := within(t, g, center.Name, km)
+			if want := bruteWithin(cities, center, km); !reflect.DeepEqual(got, want) {
+				t.Fatalf("within %v of %s = %v, want %v", km, center.Name, got, want)
+			}
+		}
+	}
+}
+
+func TestWithin(t *testing.T) {
+	cities := []City{
+		{"Fiji", -17.7, 178.1},
+		{"Samoa", -13.8, -172.1},
+		{"Longyearbyen", 78.2, 15.6},
+		{"Alert", 82.5, -62.3},
+		{"north pole", 90, 0},
+		{"Amundsen-Scott", -90, 139.3},
+		{"McMurdo", -77.8, 166.7},
+		{"Moscow", 55.75, 37.62},
+		{"Saint Petersburg", 59.94, 30.31},
+	}
+	g := loadCities(t, cities)
+	byName := make(map[string]City)
+	for _, city := range cities {
+		byName[city.Name] = city
+	}
+	distance := func(a, b string) float64 {
+		return haversine(byName[a], byName[b])
+	}
+
+	tests := []struct {
+		name   string
+		center string
+		km     float64
+		want   []string
+	}{
+		{"across the antimeridian", "Fiji", 1200, []string{"Fiji", "Samoa"}},
+		{"across the antimeridian back", "Samoa", 1200, []string{"Fiji", "Samoa"}},
+		{"over the north pole", "north pole", 1000, []string{"Alert", "north pole"}},
+		{"across the north pole", "Alert", 1800, []string{"Alert", "Longyearbyen", "north pole"}},
+		{"at the south pole", "Amundsen-Scott", 1400, []string{"Amundsen-Scott", "McMurdo"}},
+		{"the city itself", "Moscow", 0, []string{"Moscow"}},
+		{"just inside the radius", "Moscow", distance("Moscow", "Saint Petersburg") * (1 + 1e-9),
+			[]string{"Moscow", "Saint Petersburg"}},
+		{"just outside the radius", "Moscow", distance("Moscow", "Saint Petersburg") * (1 - 1e-9),
+			[]string{"Moscow"}},
+		{"the whole earth", "Moscow", 30000, []string{"Alert", "Amundsen-Scott", "Fiji", "Longyearbyen",
+			"McMurdo", "Moscow", "Saint Petersburg", "Samoa", "north pole"}},
+	}
+	for _, tt := range tests {
+		if got := within(t, g, tt.center, tt.km); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: within %v of %s = %v, want %v", tt.name, tt.km, tt.center, got, tt.want)
+		}
+	}
+
+	if _, ok := g.Within("Atlantis", 100); ok {
+		t.Error("an unknown city is found")
+	}
+}
+
+func TestEmptyGazetteer(t *testing.T) {
+	for _, data := range []string{"", "name,lat,lon\n"} {
+		g, err := LoadGazetteer(strings.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if g.Len() != 0 {
+			t.Errorf("%q: len = %d", data, g.Len())
+		}
+		if names, ok := g.Within("Moscow", 100); ok || names != nil {
+			t.Errorf("%q: within = %v %v", data, names, ok)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package geo
+
+import (
+	"math"
+	"sort"
+)
+
+// point is a city on the unit sphere, so that the euclidean distance
+// grows with the great circle one and no longitude wrapping is needed
+type point struct {
+	name string
+	xyz  [3]float64
+}
+
+func newPoint(city City) point {
+	lat := city.Lat * math.Pi / 180
+	lon := city.Lon * math.Pi / 180
+	return point{
+		name: city.Name,
+		xyz:  [3]float64{math.Cos(lat) * math.Cos(lon), math.Cos(lat) * math.Sin(lon), math.Sin(lat)},
+	}
+}
+
+func (p point) dist2(q point) float64 {
+	var d float64
+	for i := range p.xyz {
+		d += (p.xyz[i] - q.xyz[i]) * (p.xyz[i] - q.xyz[i])
+	}
+	return d
+}
+
+// kdTree is a static 3-d tree built over the median of every axis
+type kdTree struct {
+	point       point
+	axis        int
+	left, right *kdTree
+}
+
+func newKdTree(points []point) *kdTree {
+	return build(points, 0)
+}
+
+func build(points []point, depth int) *kdTree {
+	if len(points) == 0 {
+		return nil
+	}
+	axis := depth % 3
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].xyz[axis] < points[j].xyz[axis]
+	})
+	middle := len(points) / 2
+	return &kdTree{
+		point: points[middle],
+		axis:  axis,
+		left:  build(points[:middle], depth+1),
+		right: build(points[middle+1:], depth+1),
+	}
+}
+
+// within calls f for every point not farther than sqrt(radius2) from the center
+func (t *kdTree) within(center point, radius2 float64, f func(point)) {
+	if t == nil {
+		return
+	}
+	if t.point.dist2(center) <= radius2 {
+		f(t.point)
+	}
+
+	diff := center.xyz[t.axis] - t.point.xyz[t.axis]
+	near, far := t.left, t.right
+	if diff > 0 {
+		near, far = t.right, t.left
+	}
+	near.within(center, radius2, f)
+	if diff*diff <= radius2 {
+		far.within(center, radius2, f)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"hlc/app/loader"
+	"hlc/app/models"
+	"hlc/app/rest"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+// loadOpts are the flags of the commands which load data
+type loadOpts struct {
+	dataPath    string //zip, tar.gz, directory, JSON or NDJSON file or - for stdin
+	snapshotIn  string //snapshot to load instead of the data file
+	snapshotOut string //snapshot to write after loading
+	incremental bool   //import the data file into the kept dataset
+	validation  loader.Policy
+}
+
+type policyFlag struct {
+	policy *loader.Policy
+	name   string
+}
+
+func (f *policyFlag) String() string {
+	return f.name
+}
+
+func (f *policyFlag) Set(s string) error {
+	policy, err := loader.ParsePolicy(s)
+	if err != nil {
+		return err
+	}
+	*f.policy = policy
+	f.name = s
+	return nil
+}
+
+func addLoadFlags(flags *flag.FlagSet) *loadOpts {
+	opts := &loadOpts{}
+	flags.StringVar(&opts.dataPath, "data", dataFilePath, "zip, tar.gz, directory, JSON or NDJSON file of the accounts, - reads stdin")
+	flags.StringVar(&opts.snapshotIn, "snapshot-in", "", "load the accounts from the snapshot instead of the data")
+	flags.StringVar(&opts.snapshotOut, "snapshot-out", "", "write the snapshot of the loaded accounts")
+	flags.BoolVar(&opts.incremental, "incremental", false, "keep the stored accounts and upsert the accounts of the data")
+	flags.Var(&policyFlag{policy: &opts.validation, name: "lenient"}, "validate", "strict loads nothing on a validation error, skip leaves out the invalid accounts, lenient loads them")
+	return opts
+}
+
+// loadDataset fills the database and the indexes, replays and opens the wal
+// and writes the snapshot when it is requested
+func loadDataset(app *rest.App, opts opts, loadOpts *loadOpts) {
+	if loadOpts.incremental && loadOpts.snapshotIn != "" {
+		log.Fatal("[ERROR] -incremental can not be used with -snapshot-in")
+	}
+
+	if !loadOpts.incremental {
+		app.DropCollection()
+	}
+	//the index is created before the inserts, it is cheaper than on the loaded data
+	err := app.EnsureIDIndex()
+	if err != nil {
+		log.Fatal("[ERROR] ", err)
+	}
+
+	switch {
+	case loadOpts.incremental:
+		err = app.IndexCollection()
+	case loadOpts.snapshotIn != "":
+		err = app.LoadSnapshot(loadOpts.snapshotIn)
+	default:
+		loadData(app, loadOpts.dataPath, loadOpts.validation)
+	}
+	if err != nil {
+		log.Fatal("[ERROR] ", err)
+	}
+
+	if opts.walPath != "" {
+		err = app.ReplayWAL(opts.walPath)
+		if err != nil {
+			log.Fatal("[ERROR] ", err)
+		}
+		err = app.OpenWAL(opts.walPath, opts.walSync, opts.walSyncInterval)
+		if err != nil {
+			log.Fatal("[ERROR] ", err)
+		}
+	}
+
+	//the imports come after the replay and are logged, a later replay keeps them
+	if loadOpts.incremental {
+		importData(app, loadOpts.dataPath)
+	}
+
+	if loadOpts.snapshotOut != "" {
+		err = app.WriteSnapshot(loadOpts.snapshotOut)
+		if err != nil {
+			log.Fatal("[ERROR] ", err)
+		}
+	}
+}
+
+// loadData loads the accounts of every data file of the source. The strict and skip
+// policies validate the whole source before the load, lenient validates while loading.
+func loadData(app *rest.App, path string, policy loader.Policy) {
+	var validator *loader.Validator
+	if policy == loader.PolicyLenient {
+		validator = loader.NewValidator()
+	} else {
+		if path == loader.Stdin {
+			log.Fatal("[ERROR] strict and skip validation need to read the data twice, stdin can not be used")
+		}
+		var err error
+		validator, err = loader.ValidateSource(path)
+		if err != nil {
+			log.Fatal("[ERROR] ", err)
+		}
+		report := validator.Finish()
+		printReport(report)
+		if policy == loader.PolicyStrict && report.Total() > 0 {
+			log.Fatal("[ERROR] the data is not valid, nothing loaded")
+		}
+	}
+
+	source, err := loader.Open(path)
+	if err != nil {
+		log.Fatal("[ERROR] ", err)
+	}
+	defer closeSource(source)
+
+	for {
+		entry, err := source.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatal("[ERROR] ", err)
+		}
+
+		hash := sha256.New()
+		r := io.TeeReader(entry, hash)
+		index := 0
+		err = loader.Decode(r, func(accounts []models.Account) error {
+			offset := index
+			index += len(accounts)
+			app.LoadData(validator.Apply(policy, entry.Name, offset, accounts))
+			return nil
+		})
+		if err != nil {
+			log.Fatal("[ERROR] ", entry.Name, " ", err)
+		}
+		_, err = io.Copy(ioutil.Discard, r)
+		if err != nil {
+			log.Fatal("[ERROR] ", err)
+		}
+
+		//lets a later incremental import skip the file
+		err = app.MarkImported(entry.Name, hex.EncodeToString(hash.Sum(nil)))
+		if err != nil {
+			log.Println("[ERROR] ", err)
+		}
+	}
+
+	if policy == loader.PolicyLenient {
+		printReport(validator.Finish())
+	}
+}
+
+func printReport(report *loader.Report) {
+	if report.Total() == 0 {
+		log.Println("[INFO] the data is valid")
+		return
+	}
+	log.Println("[WARN] validation report:")
+	err := report.Print(os.Stderr)
+	if err != nil {
+		log.Println("[ERROR] ", err)
+	}
+}
+
+// importData upserts the accounts of the data files changed since the last import
+func importData(app *rest.App, path string) {
+	source, err := loader.Open(path)
+	if err != nil {
+		log.Fatal("[ERROR] ", err)
+	}
+	defer closeSource(source)
+
+	for {
+		entry, err := source.Next()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			log.Fatal("[ERROR] ", err)
+		}
+
+		//the file is hashed before it is imported to skip an unchanged one
+		data, err := ioutil.ReadAll(entry)
+		if err != nil {
+			log.Fatal("[ERROR] ", err)
+		}
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:])
+		imported, err := app.Imported(entry.Name, hash)
+		if err != nil {
+			log.Fatal("[ERROR] ", err)
+		}
+		if imported {
+			log.Println("[INFO] unchanged, skipped", entry.Name)
+			continue
+		}
+
+		n := 0
+		err = loader.Decode(bytes.NewReader(data), func(accounts []models.Account) error {
+			n += len(accounts)
+			return app.ImportData(accounts)
+		})
+		if err != nil {
+			log.Fatal("[ERROR] ", entry.Name, " ", err)
+		}
+		err = app.MarkImported(entry.Name, hash)
+		if err != nil {
+			log.Fatal("[ERROR] ", err)
+		}
+		log.Println("[INFO] imported", entry.Name, "accounts=", n)
+	}
+}
+
+func closeSource(source loader.Source) {
+	err := source.Close()
+	if err != nil {
+		log.Println("[ERROR] ", err)
+	}
+}
@@ -0,0 +1,136 @@
+package loader
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"hlc/app/models"
+	"io"
+	"regexp"
+)
+
+// BatchSize is the number of accounts passed to the load function at once
+const BatchSize = 10000
+
+var errFormat = errors.New(`data file is neither {"accounts":[...]} nor NDJSON`)
+
+// wrapped matches the start of a {"accounts":[...]} file
+var wrapped = regexp.MustCompile(`^\s*{\s*"accounts"\s*:\s*\[`)
+
+// Decode streams the accounts of a {"accounts":[...]} or NDJSON data file
+// to load in batches of up to BatchSize
+func Decode(r io.Reader, load func(accounts []models.Account) error) error {
+	br := bufio.NewReaderSize(r, 4096)
+	head, _ := br.Peek(256)
+	decoder := json.NewDecoder(br)
+
+	b := batch{load: load, accounts: make([]models.Account, 0, BatchSize)}
+	if !wrapped.Match(head) {
+		return decodeValues(decoder, &b)
+	}
+
+	//{ "accounts" [
+	for i := 0; i < 3; i++ {
+		if _, err := decoder.Token(); err != nil {
+			return err
+		}
+	}
+	for decoder.More() {
+		account := models.Account{}
+		err := decoder.Decode(&account)
+		if err != nil {
+			return err
+		}
+		err = b.add(account)
+		if err != nil {
+			return err
+		}
+	}
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token != json.Delim(']') {
+		return errFormat
+	}
+	return b.flush()
+}
+
+// value is an NDJSON account or, when the accounts key is set,
+// a {"accounts":[...]} object which does not start with the key
+type value struct {
+	models.Account
+	Accounts json.RawMessage `json:"accounts"`
+}
+
+// decodeValues reads the accounts of an NDJSON file. Every value must be
+// an account with an id, so an object without the accounts array is not
+// taken for a single empty account.
+func decodeValues(decoder *json.Decoder, b *batch) error {
+	for i := 0; ; i++ {
+		v := value{}
+		err := decoder.Decode(&v)
+		if err == io.EOF {
+			return b.flush()
+		}
+		if err != nil {
+			return err
+		}
+
+		if v.Accounts != nil {
+			if i > 0 {
+				return errFormat
+			}
+			return decodeObject(decoder, v.Accounts, b)
+		}
+		if v.ID == 0 {
+			return errFormat
+		}
+		err = b.add(v.Account)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// decodeObject loads the accounts array of the object read in full,
+// the object must be the only value of the file
+func decodeObject(decoder *json.Decoder, raw json.RawMessage, b *batch) error {
+	var accounts []models.Account
+	err := json.Unmarshal(raw, &accounts)
+	if err != nil || accounts == nil {
+		return errFormat
+	}
+	if decoder.More() {
+		return errFormat
+	}
+	for _, account := range accounts {
+		err = b.add(account)
+		if err != nil {
+			return err
+		}
+	}
+	return b.flush()
+}
+
+type batch struct {
+	load     func(accounts []models.Account) error
+	accounts []models.Account
+}
+
+func (b *batch) add(account models.Account) error {
+	b.accounts = append(b.accounts, account)
+	if len(b.accounts) < BatchSize {
+		return nil
+	}
+	return b.flush()
+}
+
+func (b *batch) flush() error {
+	if len(b.accounts) == 0 {
+		return nil
+	}
+	err := b.load(b.accounts)
+	b.accounts = make([]models.Account, 0, BatchSize)
+	return err
+}
@@ -0,0 +1,52 @@
+package loader
+
+import (
+	"hlc/app/models"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		ids  []int
+		err  bool
+	}{
+		{name: "wrapped", data: `{"accounts":[{"id":1},{"id":2}]}`, ids: []int{1, 2}},
+		{name: "wrapped empty", data: ` { "accounts" : [] }`, ids: []int{}},
+		{name: "wrapped with another key first", data: `{"version":1,"accounts":[{"id":1},{"id":2}]}`, ids: []int{1, 2}},
+		{name: "ndjson", data: "{\"id\":1}\n{\"id\":2,\"email\":\"a@b\"}\n", ids: []int{1, 2}},
+		{name: "empty", data: "", ids: []int{}},
+		{name: "object without accounts", data: `{"users":[{"id":1}]}`, err: true},
+		{name: "accounts not an array", data: `{"version":1,"accounts":{"id":1}}`, err: true},
+		{name: "accounts null", data: `{"version":1,"accounts":null}`, err: true},
+		{name: "object after the accounts", data: `{"version":1,"accounts":[]}` + "\n{\"id\":1}", err: true},
+		{name: "accounts after ndjson", data: "{\"id\":1}\n{\"accounts\":[]}", err: true},
+		{name: "ndjson value without id", data: "{\"id\":1}\n{\"email\":\"a@b\"}\n", err: true},
+	}
+
+	for _, tt := range tests {
+		ids := []int{}
+		err := Decode(strings.NewReader(tt.data), func(accounts []models.Account) error {
+			for _, account := range accounts {
+				ids = append(ids, account.ID)
+			}
+			return nil
+		})
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: no error, accounts %v", tt.name, ids)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(ids, tt.ids) {
+			t.Errorf("%s: ids = %v, want %v", tt.name, ids, tt.ids)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package loader
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Stdin is the path of the source read from the standard input
+const Stdin = "-"
+
+// Entry is a data file of a source
+type Entry struct {
+	Name string
+	io.Reader
+}
+
+// Source yields the data files in a stable order
+type Source interface {
+	// Next returns the next data file or io.EOF after the last one,
+	// the entry is readable until the next call
+	Next() (Entry, error)
+	Close() error
+}
+
+// Open detects the format of the path, which is a zip, a tar.gz,
+// a directory, a plain or gzipped JSON stream or Stdin
+func Open(path string) (Source, error) {
+	if path == Stdin {
+		return detect(Stdin, os.Stdin, ioutil.NopCloser(os.Stdin))
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return openDir(path)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	source, err := detect(path, file, file)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return source, nil
+}
+
+var (
+	zipMagic  = []byte("PK\x03\x04")
+	gzipMagic = []byte("\x1f\x8b")
+)
+
+// tarMagicOffset is the position of "ustar" in the first tar header
+const tarMagicOffset = 257
+
+func detect(name string, r io.Reader, closer io.Closer) (Source, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(zipMagic))
+
+	switch {
+	case bytes.HasPrefix(magic, zipMagic):
+		return openZip(name, br, closer)
+	case bytes.HasPrefix(magic, gzipMagic):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		unzipped := bufio.NewReaderSize(gz, 4096)
+		header, _ := unzipped.Peek(tarMagicOffset + 5)
+		if len(header) == tarMagicOffset+5 && string(header[tarMagicOffset:]) == "ustar" {
+			return &tarSource{tr: tar.NewReader(unzipped), closer: closer}, nil
+		}
+		return &streamSource{entry: Entry{Name: strings.TrimSuffix(name, ".gz"), Reader: unzipped}, closer: closer}, nil
+	}
+	return &streamSource{entry: Entry{Name: name, Reader: br}, closer: closer}, nil
+}
+
+// streamSource is a single JSON or NDJSON file
+type streamSource struct {
+	entry  Entry
+	done   bool
+	closer io.Closer
+}
+
+func (s *streamSource) Next() (Entry, error) {
+	if s.done {
+		return Entry{}, io.EOF
+	}
+	s.done = true
+	return s.entry, nil
+}
+
+func (s *streamSource) Close() error {
+	return s.closer.Close()
+}
+
+type zipSource struct {
+	files   []*zip.File
+	current io.ReadCloser
+	closer  io.Closer
+}
+
+// openZip reads the archive, which needs random access, from the file
+// or from memory when it comes from the standard input
+func openZip(name string, r io.Reader, closer io.Closer) (Source, error) {
+	if file, ok := closer.(*os.File); ok && name != Stdin {
+		info, err := file.Stat()
+		if err != nil {
+			return nil, err
+		}
+		zr, err := zip.NewReader(file, info.Size())
+		if err != nil {
+			return nil, err
+		}
+		return &zipSource{files: zr.File, closer: closer}, nil
+	}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	return &zipSource{files: zr.File, closer: closer}, nil
+}
+
+func (s *zipSource) Next() (Entry, error) {
+	err := s.closeCurrent()
+	if err != nil {
+		return Entry{}, err
+	}
+	for len(s.files) > 0 {
+		file := s.files[0]
+		s.files = s.files[1:]
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		s.current, err = file.Open()
+		if err != nil {
+			return Entry{}, err
+		}
+		return Entry{Name: file.Name, Reader: s.current}, nil
+	}
+	return Entry{}, io.EOF
+}
+
+func (s *zipSource) closeCurrent() error {
+	if s.current == nil {
+		return nil
+	}
+	err := s.current.Close()
+	s.current = nil
+	return err
+}
+
+func (s *zipSource) Close() error {
+	err := s.closeCurrent()
+	closeErr := s.closer.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+type tarSource struct {
+	tr     *tar.Reader
+	closer io.Closer
+}
+
+func (s *tarSource) Next() (Entry, error) {
+	for {
+		header, err := s.tr.Next()
+		if err != nil {
+			return Entry{}, err
+		}
+		if header.Typeflag == tar.TypeReg {
+			return Entry{Name: header.Name, Reader: s.tr}, nil
+		}
+	}
+}
+
+func (s *tarSource) Close() error {
+	return s.closer.Close()
+}
+
+// dataExtensions are the files of a directory source, gzipped or not
+var dataExtensions = []string{".json", ".ndjson", ".jsonl"}
+
+type dirSource struct {
+	root    string
+	names   []string //relative to the root, sorted
+	current *os.File
+}
+
+func openDir(root string) (Source, error) {
+	names := make([]string, 0)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() || !isDataFile(path) {
+			return nil
+		}
+		name, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		names = append(names, name)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return &dirSource{root: root, names: names}, nil
+}
+
+func isDataFile(path string) bool {
+	ext := filepath.Ext(strings.TrimSuffix(path, ".gz"))
+	for _, dataExt := range dataExtensions {
+		if ext == dataExt {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *dirSource) Next() (Entry, error) {
+	err := s.closeCurrent()
+	if err != nil {
+		return Entry{}, err
+	}
+	if len(s.names) == 0 {
+		return Entry{}, io.EOF
+	}
+	name := s.names[0]
+	s.names = s.names[1:]
+
+	s.current, err = os.Open(filepath.Join(s.root, name))
+	if err != nil {
+		return Entry{}, err
+	}
+	if !strings.HasSuffix(name, ".gz") {
+		return Entry{Name: name, Reader: s.current}, nil
+	}
+	gz, err := gzip.NewReader(s.current)
+	if err != nil {
+		return Entry{}, err
+	}
+	return Entry{Name: strings.TrimSuffix(name, ".gz"), Reader: gz}, nil
+}
+
+func (s *dirSource) closeCurrent() error {
+	if s.current == nil {
+		return nil
+	}
+	err := s.current.Close()
+	s.current = nil
+	return err
+}
+
+func (s *dirSource) Close() error {
+	return s.closeCurrent()
+}
@@ -0,0 +1,358 @@
+package loader
+
+import (
+	"errors"
+	"fmt"
+	"hlc/app/models"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Policy tells what is done with the accounts violating the validation rules
+type Policy int
+
+const (
+	PolicyLenient Policy = iota //load everything and report the violations
+	PolicyStrict                //load nothing when there is a violation
+	PolicySkip                  //leave out the violating accounts and the likes of missing ids
+)
+
+func ParsePolicy(s string) (Policy, error) {
+	switch s {
+	case "lenient":
+		return PolicyLenient, nil
+	case "strict":
+		return PolicyStrict, nil
+	case "skip":
+		return PolicySkip, nil
+	}
+	return 0, errors.New("unknown validation policy " + s)
+}
+
+// validation rules
+const (
+	ruleID             = "id"
+	ruleDuplicateID    = "duplicate_id"
+	ruleEmail          = "email"
+	ruleDuplicateEmail = "duplicate_email"
+	rulePhone          = "phone"
+	ruleDuplicatePhone = "duplicate_phone"
+	ruleSex            = "sex"
+	ruleBirth          = "birth_range"
+	ruleJoined         = "joined_range"
+	ruleStatus         = "status"
+	ruleLength         = "length"
+	rulePremium        = "premium"
+	ruleLike           = "like_missing_id"
+)
+
+// ranges of the timestamps
+const (
+	minBirth   = -631152000 //01.01.1950
+	maxBirth   = 1104537600 //01.01.2005
+	minJoined  = 1293840000 //01.01.2011
+	maxJoined  = 1514764800 //01.01.2018
+	minPremium = 1514764800 //01.01.2018
+)
+
+// reportExamples is the number of account ids kept for every group of the report
+const reportExamples = 5
+
+type position struct {
+	file  string
+	index int //of the account in the file
+}
+
+// pendingLikes are the likes of a file given to an id not seen yet
+type pendingLikes struct {
+	count int
+	liker int //id of the first liker
+}
+
+// Validator checks the accounts of a dataset file by file, the rules across
+// the accounts (unique id, email and phone, liked ids) span all the files
+type Validator struct {
+	report  *Report
+	ids     map[int]bool //id -> the first account with the id passed the rules
+	emails  map[string]bool
+	phones  map[string]bool
+	skipped map[position]bool
+	pending map[int]map[string]*pendingLikes //liked ids not seen yet -> likes by file
+}
+
+func NewValidator() *Validator {
+	return &Validator{
+		report:  NewReport(),
+		ids:     make(map[int]bool),
+		emails:  make(map[string]bool),
+		phones:  make(map[string]bool),
+		skipped: make(map[position]bool),
+		pending: make(map[int]map[string]*pendingLikes),
+	}
+}
+
+// Check validates the account at the index of the file and reports whether it passed
+func (v *Validator) Check(file string, index int, account models.Account) bool {
+	violations := make([]string, 0)
+	violate := func(rule string) {
+		violations = append(violations, rule)
+	}
+
+	switch {
+	case account.ID <= 0:
+		violate(ruleID)
+	case hasKey(v.ids, account.ID):
+		violate(ruleDuplicateID)
+	}
+
+	switch {
+	case len(account.Email) > 100 || strings.Count(account.Email, "@") != 1 ||
+		strings.HasPrefix(account.Email, "@") || strings.HasSuffix(account.Email, "@"):
+		violate(ruleEmail)
+	case v.emails[account.Email]:
+		violate(ruleDuplicateEmail)
+	}
+
+	if account.Phone != "" {
+		switch {
+		case len(account.Phone) > 16:
+			violate(rulePhone)
+		case v.phones[account.Phone]:
+			violate(ruleDuplicatePhone)
+		}
+	}
+
+	if account.Sex != "m" && account.Sex != "f" {
+		violate(ruleSex)
+	}
+	if account.Birth < minBirth || account.Birth > maxBirth {
+		violate(ruleBirth)
+	}
+	if account.Joined < minJoined || account.Joined > maxJoined {
+		violate(ruleJoined)
+	}
+	if !contains(models.Statuses, account.Status) {
+		violate(ruleStatus)
+	}
+	if len(account.FName) > 50 || len(account.SName) > 50 || len(account.Country) > 50 || len(account.City) > 50 {
+		violate(ruleLength)
+	}
+	for _, interest := range account.Interests {
+		if len(interest) > 100 {
+			violate(ruleLength)
+			break
+		}
+	}
+	if account.Premium != nil && (account.Premium.Start < minPremium || account.Premium.Start >= account.Premium.Finish) {
+		violate(rulePremium)
+	}
+
+	for _, rule := range violations {
+		v.report.add(rule, file, account.ID, 1)
+	}
+	passed := len(violations) == 0
+	if !passed {
+		v.skipped[position{file: file, index: index}] = true
+	}
+
+	if account.ID > 0 && !hasKey(v.ids, account.ID) {
+		v.ids[account.ID] = passed
+		delete(v.pending, account.ID)
+	}
+	if account.Email != "" {
+		v.emails[account.Email] = true
+	}
+	if account.Phone != "" {
+		v.phones[account.Phone] = true
+	}
+
+	for _, like := range account.Likes {
+		if hasKey(v.ids, like.ID) {
+			continue
+		}
+		files, ok := v.pending[like.ID]
+		if !ok {
+			files = make(map[string]*pendingLikes)
+			v.pending[like.ID] = files
+		}
+		likes, ok := files[file]
+		if !ok {
+			likes = &pendingLikes{liker: account.ID}
+			files[file] = likes
+		}
+		likes.count++
+	}
+	return passed
+}
+
+// Finish reports the likes of the ids missing from the whole dataset
+func (v *Validator) Finish() *Report {
+	for _, files := range v.pending {
+		for file, likes := range files {
+			v.report.add(ruleLike, file, likes.liker, likes.count)
+		}
+	}
+	v.pending = make(map[int]map[string]*pendingLikes)
+	return v.report
+}
+
+// Skipped reports whether the account at the index of the file violated a rule
+func (v *Validator) Skipped(file string, index int) bool {
+	return v.skipped[position{file: file, index: index}]
+}
+
+// Kept reports whether the account with the id is loaded under PolicySkip
+func (v *Validator) Kept(id int) bool {
+	return v.ids[id]
+}
+
+// Filter leaves out the skipped accounts of the file and the likes of the
+// accounts which are not kept, offset is the index of the first account
+func (v *Validator) Filter(file string, offset int, accounts []models.Account) []models.Account {
+	filtered := accounts[:0]
+	for i, account := range accounts {
+		if v.Skipped(file, offset+i) {
+			continue
+		}
+		likes := account.Likes[:0]
+		for _, like := range account.Likes {
+			if v.Kept(like.ID) {
+				likes = append(likes, like)
+			}
+		}
+		account.Likes = likes
+		filtered = append(filtered, account)
+	}
+	return filtered
+}
+
+// Apply applies the policy to the accounts of the file as they are loaded, offset
+// is the index of the first account. Lenient checks the accounts and keeps them,
+// skip filters them and strict keeps them, the source was validated before the load.
+func (v *Validator) Apply(policy Policy, file string, offset int, accounts []models.Account) []models.Account {
+	switch policy {
+	case PolicyLenient:
+		for i, account := range accounts {
+			v.Check(file, offset+i, account)
+		}
+	case PolicySkip:
+		return v.Filter(file, offset, accounts)
+	}
+	return accounts
+}
+
+func hasKey(m map[int]bool, k int) bool {
+	_, ok := m[k]
+	return ok
+}
+
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Group is the number of violations of a rule in a file
+type Group struct {
+	Rule     string `json:"rule"`
+	File     string `json:"file"`
+	Count    int    `json:"count"`
+	Examples []int  `json:"examples"` //ids of the first violating accounts
+}
+
+// Report sums up the violations by rule and file
+type Report struct {
+	groups map[[2]string]*Group
+}
+
+func NewReport() *Report {
+	return &Report{groups: make(map[[2]string]*Group)}
+}
+
+func (r *Report) add(rule, file string, id, n int) {
+	key := [2]string{rule, file}
+	group, ok := r.groups[key]
+	if !ok {
+		group = &Group{Rule: rule, File: file, Examples: make([]int, 0, reportExamples)}
+		r.groups[key] = group
+	}
+	group.Count += n
+	if len(group.Examples) < reportExamples {
+		group.Examples = append(group.Examples, id)
+	}
+}
+
+// Total is the number of violations
+func (r *Report) Total() int {
+	total := 0
+	for _, group := range r.groups {
+		total += group.Count
+	}
+	return total
+}
+
+// Groups returns the groups ordered by rule and file
+func (r *Report) Groups() []Group {
+	groups := make([]Group, 0, len(r.groups))
+	for _, group := range r.groups {
+		groups = append(groups, *group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Rule != groups[j].Rule {
+			return groups[i].Rule < groups[j].Rule
+		}
+		return groups[i].File < groups[j].File
+	})
+	return groups
+}
+
+// Print writes the report as a table, one line per group
+func (r *Report) Print(w io.Writer) error {
+	for _, group := range r.Groups() {
+		_, err := fmt.Fprintf(w, "%-16s %-24s %8d  e.g. ids %v\n", group.Rule, group.File, group.Count, group.Examples)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%d violations\n", r.Total())
+	return err
+}
+
+// ValidateSource checks every account of the source at the path,
+// the report is completed by Finish
+func ValidateSource(path string) (*Validator, error) {
+	source, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = source.Close()
+	}()
+
+	v := NewValidator()
+	for {
+		entry, err := source.Next()
+		if err == io.EOF {
+			return v, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		index := 0
+		err = Decode(entry, func(accounts []models.Account) error {
+			for _, account := range accounts {
+				v.Check(entry.Name, index, account)
+				index++
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", entry.Name, err)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package loader
+
+import (
+	"bytes"
+	"hlc/app/models"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// load reads the dataset at the path under the policy the way the load command does:
+// strict and skip validate the whole dataset first, lenient validates while loading
+func load(t *testing.T, path string, policy Policy) ([]models.Account, *Report) {
+	var validator *Validator
+	var report *Report
+	if policy == PolicyLenient {
+		validator = NewValidator()
+	} else {
+		var err error
+		validator, err = ValidateSource(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		report = validator.Finish()
+		if policy == PolicyStrict && report.Total() > 0 {
+			return []models.Account{}, report
+		}
+	}
+
+	source, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+	loaded := make([]models.Account, 0)
+	for {
+		entry, err := source.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		index := 0
+		err = Decode(entry, func(accounts []models.Account) error {
+			offset := index
+			index += len(accounts)
+			loaded = append(loaded, validator.Apply(policy, entry.Name, offset, accounts)...)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if policy == PolicyLenient {
+		report = validator.Finish()
+	}
+	return loaded, report
+}
+
+// likes lists the ids of the loaded accounts with the ids they like,
+// the likes of the accounts with the same id are put together
+func likes(accounts []models.Account) map[int][]int {
+	liked := make(map[int][]int)
+	for _, account := range accounts {
+		if _, ok := liked[account.ID]; !ok {
+			liked[account.ID] = []int{}
+		}
+		for _, like := range account.Likes {
+			liked[account.ID] = append(liked[account.ID], like.ID)
+		}
+	}
+	return liked
+}
+
+var invalidGroups = []Group{
+	{Rule: ruleBirth, File: "accounts_2.ndjson", Count: 1, Examples: []int{6}},
+	{Rule: ruleDuplicateEmail, File: "accounts_2.ndjson", Count: 1, Examples: []int{5}},
+	{Rule: ruleDuplicateID, File: "accounts_1.json", Count: 1, Examples: []int{2}},
+	{Rule: ruleEmail, File: "accounts_1.json", Count: 1, Examples: []int{3}},
+	{Rule: ruleLike, File: "accounts_1.json", Count: 2, Examples: []int{1}},
+	{Rule: ruleLike, File: "accounts_2.ndjson", Count: 1, Examples: []int{8}},
+	{Rule: rulePremium, File: "accounts_2.ndjson", Count: 1, Examples: []int{8}},
+	{Rule: ruleSex, File: "accounts_1.json", Count: 1, Examples: []int{2}},
+}
+
+func TestPolicies(t *testing.T) {
+	valid := filepath.Join("testdata", "valid")
+	invalid := filepath.Join("testdata", "invalid")
+	tests := []struct {
+		name   string
+		path   string
+		policy Policy
+		likes  map[int][]int
+		groups []Group
+	}{
+		{"strict valid", valid, PolicyStrict, map[int][]int{1: {2}, 2: {1}}, []Group{}},
+		{"strict invalid", invalid, PolicyStrict, map[int][]int{}, invalidGroups},
+		//the violating accounts and the likes of the ids not loaded are left out
+		{"skip", invalid, PolicySkip, map[int][]int{1: {2}, 2: {7}, 7: {2}}, invalidGroups},
+		{"lenient", invalid, PolicyLenient, map[int][]int{
+			1: {2, 99, 99}, 2: {7}, 3: {}, 5: {}, 6: {3, 1}, 7: {6, 2}, 8: {100},
+		}, invalidGroups},
+		{"lenient valid", valid, PolicyLenient, map[int][]int{1: {2}, 2: {1}}, []Group{}},
+	}
+	for _, tt := range tests {
+		loaded, report := load(t, tt.path, tt.policy)
+		if got := likes(loaded); !reflect.DeepEqual(got, tt.likes) {
+			t.Errorf("%s: loaded %v, want %v", tt.name, got, tt.likes)
+		}
+		if tt.policy == PolicyLenient && tt.path == invalid && len(loaded) != 8 {
+			t.Errorf("%s: %d accounts loaded, want all 8", tt.name, len(loaded))
+		}
+		if got := report.Groups(); !reflect.DeepEqual(got, tt.groups) {
+			t.Errorf("%s: report %+v, want %+v", tt.name, got, tt.groups)
+		}
+	}
+}
+
+func TestReportPrint(t *testing.T) {
+	validator, err := ValidateSource(filepath.Join("testdata", "invalid"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := validator.Finish()
+	if report.Total() != 9 {
+		t.Errorf("total = %d, want 9", report.Total())
+	}
+
+	var out bytes.Buffer
+	err = report.Print(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != len(invalidGroups)+1 {
+		t.Fatalf("report:\n%s", out.String())
+	}
+	//one line per rule and file in the order of the groups
+	for i, group := range invalidGroups {
+		fields := strings.Fields(lines[i])
+		if fields[0] != group.Rule || fields[1] != group.File {
+			t.Errorf("line %d = %q, want %s %s", i, lines[i], group.Rule, group.File)
+		}
+	}
+	if lines[len(lines)-1] != "9 violations" {
+		t.Errorf("last line = %q", lines[len(lines)-1])
+	}
+}
+
+// The examples of a group are its first violating accounts.
+func TestReportExamples(t *testing.T) {
+	v := NewValidator()
+	for id := 1; id <= reportExamples+3; id++ {
+		v.Check("a.json", id-1, models.Account{ID: id, Email: "x"})
+	}
+	for _, group := range v.Finish().Groups() {
+		if group.Rule == ruleEmail && (group.Count != reportExamples+3 || !reflect.DeepEqual(group.Examples, []int{1, 2, 3, 4, 5})) {
+			t.Errorf("email group = %+v", group)
+		}
+	}
+}
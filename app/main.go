@@ -1,14 +1,18 @@
 package main
 
 import (
-	"archive/zip"
 	"bufio"
-	"encoding/json"
-	"hlc/app/models"
+	"flag"
+	"fmt"
+	"hlc/app/geo"
 	"hlc/app/rest"
+	"hlc/app/storage"
 	"log"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 )
 
 const (
@@ -18,8 +22,24 @@ const (
 	listenAddrEnvName = "SERVER_ADDR"
 	defaultListenAddr = ":80"
 
-	optionsFilePath = "/tmp/data/options.txt" //todo docker
-	dataFilePath    = "/tmp/data/data.zip"
+	recommendStrategyEnvName = "RECOMMEND_STRATEGY"
+	suggestDecayEnvName      = "SUGGEST_DECAY_DAYS" //like half-life, disabled by default
+
+	suggestJobIntervalEnvName = "SUGGEST_JOB_INTERVAL" //e.g. 10m, the job is disabled by default
+	suggestJobSizeEnvName     = "SUGGEST_JOB_SIZE"
+	defaultSuggestJobSize     = 100
+
+	walPathEnvName         = "WAL_PATH" //log of the mutations, disabled by default
+	walSyncEnvName         = "WAL_SYNC" //always|interval|never
+	defaultWALSync         = "interval"
+	walSyncIntervalEnvName = "WAL_SYNC_INTERVAL"
+	defaultWALSyncInterval = time.Second
+
+	adminTokenEnvName = "ADMIN_TOKEN" //enables /admin/export, kept out of opts so it is not logged
+
+	optionsFilePath   = "/tmp/data/options.txt" //todo docker
+	dataFilePath      = "/tmp/data/data.zip"
+	gazetteerFilePath = "/tmp/data/cities.csv" //optional
 
 	//optionsFilePath = "/home/zzsdeo/tmp/data/options.txt" //todo hp
 	//dataFilePath    = "/home/zzsdeo/tmp/data/data.zip"
@@ -28,45 +48,136 @@ const (
 	//dataFilePath    = "./tmp/data/data.zip"
 )
 
+// opts is the configuration shared by the commands, it comes from
+// the environment and options.txt
 type opts struct {
-	mongoAddr  string
-	listenAddr string
-	now        int
+	mongoAddr          string
+	listenAddr         string
+	recommendStrategy  string
+	suggestDecayDays   float64
+	suggestJobInterval time.Duration
+	suggestJobSize     int
+	walPath            string
+	walSync            storage.SyncPolicy
+	walSyncInterval    time.Duration
+	now                int
+}
+
+type command struct {
+	run  func(opts opts, args []string)
+	help string
+}
+
+var commands = map[string]command{
+	"serve":    {serve, "load the data and start the server, the default command"},
+	"load":     {load, "load the data into the database without starting the server"},
+	"validate": {validate, "check the data and print the validation report"},
+	"export":   {export, "write the stored accounts as NDJSON or zip"},
+	"snapshot": {snapshot, "write the snapshot of the stored accounts"},
+	"bench":    {bench, "time the requests against the stored accounts"},
+	"query":    {query, "run a filter or group query and print the JSON response"},
 }
 
 func main() {
-	opts := parseOpts()
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
 
-	app := rest.App{}
+	cmd.run(parseOpts(), args)
+}
 
-	app.Initialize(opts.mongoAddr)
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
 
-	app.SetNow(opts.now)
+	fmt.Fprintln(os.Stderr, "usage: hlc [command] [flags]")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].help)
+	}
+}
+
+// serve loads the data and serves the API
+func serve(opts opts, args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	loadOpts := addLoadFlags(flags)
+	_ = flags.Parse(args)
 
-	app.DropCollection()
+	app := newApp(opts, true)
+	loadDataset(app, opts, loadOpts)
+
+	//app.CheckDB()
 
-	r, err := readZip()
+	//app.DropAllIndexes()
+
+	//app.CreateIndexes(false)
+
+	if opts.suggestJobInterval > 0 {
+		app.StartSuggestJob(opts.suggestJobInterval, opts.suggestJobSize)
+	}
+
+	app.Run(opts.listenAddr)
+}
+
+// load fills the database the same way as serve and exits
+func load(opts opts, args []string) {
+	flags := flag.NewFlagSet("load", flag.ExitOnError)
+	loadOpts := addLoadFlags(flags)
+	_ = flags.Parse(args)
+
+	app := newApp(opts, true)
+	loadDataset(app, opts, loadOpts)
+	app.CheckDB()
+	err := app.CloseWAL()
 	if err != nil {
 		log.Fatal("[ERROR] ", err)
 	}
+}
+
+// newApp connects to the database and applies the configuration,
+// the current time of options.txt is required by the commands which load data
+func newApp(opts opts, needNow bool) *rest.App {
+	if opts.now == 0 {
+		if needNow {
+			log.Fatal("[ERROR] no current time in ", optionsFilePath)
+		}
+		opts.now = int(time.Now().Unix())
+		log.Println("[INFO] no current time in", optionsFilePath, "using the clock")
+	}
+
+	app := rest.App{}
+
+	app.Initialize(opts.mongoAddr)
+
+	app.SetNow(opts.now)
 
-	for _, file := range r.File {
-		data, err := parseData(file)
+	if opts.recommendStrategy != "" {
+		err := app.SetScorer(opts.recommendStrategy)
 		if err != nil {
 			log.Fatal("[ERROR] ", err)
 		}
-		app.LoadData(data.Accounts)
 	}
 
-	//app.CheckDB()
-
-	//app.DropAllIndexes()
+	err := app.SetDecay(opts.suggestDecayDays)
+	if err != nil {
+		log.Fatal("[ERROR] ", err)
+	}
 
-	//app.CreateIndexes(false)
+	app.SetAdminToken(os.Getenv(adminTokenEnvName))
 
-	app.Run(opts.listenAddr)
+	loadGazetteer(&app)
+	return &app
 }
 
+// parseOpts reads the configuration, a missing options.txt leaves now unset
 func parseOpts() opts {
 	opts := opts{}
 
@@ -80,7 +191,54 @@ func parseOpts() opts {
 		opts.listenAddr = defaultListenAddr
 	}
 
+	opts.recommendStrategy = os.Getenv(recommendStrategyEnvName)
+
+	var err error
+	if interval := os.Getenv(suggestJobIntervalEnvName); interval != "" {
+		opts.suggestJobInterval, err = time.ParseDuration(interval)
+		if err != nil {
+			log.Fatal("[ERROR] ", err)
+		}
+	}
+
+	opts.suggestJobSize = defaultSuggestJobSize
+	if size := os.Getenv(suggestJobSizeEnvName); size != "" {
+		opts.suggestJobSize, err = strconv.Atoi(size)
+		if err != nil || opts.suggestJobSize <= 0 {
+			log.Fatal("[ERROR] bad ", suggestJobSizeEnvName, " ", size)
+		}
+	}
+
+	if decay := os.Getenv(suggestDecayEnvName); decay != "" {
+		opts.suggestDecayDays, err = strconv.ParseFloat(decay, 64)
+		if err != nil {
+			log.Fatal("[ERROR] ", err)
+		}
+	}
+
+	opts.walPath = os.Getenv(walPathEnvName)
+
+	sync := os.Getenv(walSyncEnvName)
+	if sync == "" {
+		sync = defaultWALSync
+	}
+	opts.walSync, err = storage.ParseSyncPolicy(sync)
+	if err != nil {
+		log.Fatal("[ERROR] ", err)
+	}
+
+	opts.walSyncInterval = defaultWALSyncInterval
+	if interval := os.Getenv(walSyncIntervalEnvName); interval != "" {
+		opts.walSyncInterval, err = time.ParseDuration(interval)
+		if err != nil || opts.walSyncInterval <= 0 {
+			log.Fatal("[ERROR] bad ", walSyncIntervalEnvName, " ", interval)
+		}
+	}
+
 	file, err := os.Open(optionsFilePath)
+	if os.IsNotExist(err) {
+		return opts
+	}
 	if err != nil {
 		log.Fatal("[ERROR] ", err)
 	}
@@ -99,19 +257,15 @@ func parseOpts() opts {
 	return opts
 }
 
-func readZip() (*zip.ReadCloser, error) {
-	r, err := zip.OpenReader(dataFilePath)
-	if err != nil {
-		return nil, err
+// loadGazetteer loads the optional city coordinates used by within_km
+func loadGazetteer(app *rest.App) {
+	file, err := os.Open(gazetteerFilePath)
+	if os.IsNotExist(err) {
+		log.Println("[INFO] no gazetteer, within_km is disabled")
+		return
 	}
-	return r, nil
-}
-
-func parseData(f *zip.File) (models.Accounts, error) {
-	accounts := models.Accounts{}
-	file, err := f.Open()
 	if err != nil {
-		return accounts, err
+		log.Fatal("[ERROR] ", err)
 	}
 	defer func() {
 		err = file.Close()
@@ -120,10 +274,10 @@ func parseData(f *zip.File) (models.Accounts, error) {
 		}
 	}()
 
-	err = json.NewDecoder(file).Decode(&accounts)
+	gazetteer, err := geo.LoadGazetteer(file)
 	if err != nil {
-		return accounts, err
+		log.Fatal("[ERROR] ", err)
 	}
-
-	return accounts, nil
+	app.SetGazetteer(gazetteer)
+	log.Println("[INFO] gazetteer cities loaded=", gazetteer.Len())
 }
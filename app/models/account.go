@@ -2,8 +2,6 @@ package models
 
 import (
 	"errors"
-	"math"
-	"sort"
 )
 
 type Account struct {
@@ -22,7 +20,11 @@ type Account struct {
 	interestsMap map[string]bool
 	Premium      *Premium `json:"premium,omitempty" bson:"premium,omitempty"`
 	Likes        []Like   `json:"likes,omitempty" bson:"likes,omitempty"`
-	likesMap     map[int][]int
+	Blocked      []int    `json:"blocked,omitempty" bson:"blocked,omitempty"` //ids never recommended to the account, optional
+	AgeMin       int      `json:"age_min,omitempty" bson:"age_min,omitempty"` //preferred age of recommend and suggest results, optional
+	AgeMax       int      `json:"age_max,omitempty" bson:"age_max,omitempty"` //preferred age of recommend and suggest results, optional
+	//number of likes received from other accounts, maintained on load
+	LikesReceived int `json:"-" bson:"likes_received,omitempty"`
 }
 
 type Premium struct {
@@ -35,6 +37,17 @@ type Like struct {
 	TS int `json:"ts,omitempty" bson:"ts,omitempty"` //timestamp when like has been set
 }
 
+// NewLike is a like posted to the likes endpoint
+type NewLike struct {
+	Likee int `json:"likee"` //id of the liked account
+	TS    int `json:"ts"`    //timestamp when like has been set
+	Liker int `json:"liker"` //id of the account which set the like
+}
+
+type NewLikes struct {
+	Likes []NewLike `json:"likes"`
+}
+
 type Accounts struct {
 	Accounts []Account `json:"accounts"`
 }
@@ -49,6 +62,11 @@ func (a *Accounts) ExtractAccountByID(id int) (Account, error) {
 	return Account{}, errors.New("account not found")
 }
 
+// AgePreference is the stored age range applied when a request does not set one
+func (a *Account) AgePreference() AgeRange {
+	return AgeRange{Min: a.AgeMin, Max: a.AgeMax}
+}
+
 func (a *Account) PrepareInterestsMap() {
 	a.interestsMap = make(map[string]bool)
 	for _, i := range a.Interests {
@@ -56,72 +74,90 @@ func (a *Account) PrepareInterestsMap() {
 	}
 }
 
+// sharesInterest reports whether the account has any of the interests
+// of a, PrepareInterestsMap must be called on a before
+func (a *Account) sharesInterest(account *Account) bool {
+	for _, interest := range account.Interests {
+		if a.interestsMap[interest] {
+			return true
+		}
+	}
+	return false
+}
+
+// weights of the compatibility components
+const (
+	ageCompatibility      = 1000000000
+	interestCompatibility = 10000000000
+	statusCompatibility   = 100000000000
+	premiumCompatibility  = 1000000000000
+)
+
+// Statuses lists the account statuses from the most to the least compatible
+var Statuses = []string{"свободны", "всё сложно", "заняты"}
+
+var statusTiers = map[string]int{
+	"свободны":   3,
+	"всё сложно": 2,
+	"заняты":     1,
+}
+
 func (a *Account) CheckCompatibility(account Account, now int) int {
+	return a.compatibility(&account, now, nil)
+}
+
+// ExplainCompatibility returns the components of CheckCompatibility
+func (a *Account) ExplainCompatibility(account Account, now int) ScoreComponents {
+	components := ScoreComponents{SharedInterests: make([]string, 0)}
+	components.Score = float64(a.compatibility(&account, now, &components))
+	return components
+}
+
+// compatibility computes the score and fills the components when they are requested
+func (a *Account) compatibility(account *Account, now int, components *ScoreComponents) int {
 	var compatibility int
-	if account.Birth > a.Birth {
-		compatibility = 1000000000 - account.Birth + a.Birth
-	} else {
-		compatibility = 1000000000 - a.Birth + account.Birth
+	ageGap := account.Birth - a.Birth
+	if ageGap < 0 {
+		ageGap = -ageGap
 	}
+	compatibility = ageCompatibility - ageGap
 
+	shared := 0
 	for _, interest := range account.Interests {
 		if _, ok := a.interestsMap[interest]; ok {
-			compatibility += 10000000000
+			shared++
+			if components != nil {
+				components.SharedInterests = append(components.SharedInterests, interest)
+			}
 		}
 	}
+	compatibility += shared * interestCompatibility
 
-	switch account.Status {
-	case "свободны":
-		compatibility += 300000000000
-	case "всё сложно":
-		compatibility += 200000000000
-	case "заняты":
-		compatibility += 100000000000
-	}
+	tier := statusTiers[account.Status]
+	compatibility += tier * statusCompatibility
 
-	if account.isPremium(now) {
-		compatibility += 1000000000000
+	premium := account.isPremium(now)
+	if premium {
+		compatibility += premiumCompatibility
 	}
 
+	if components != nil {
+		components.Premium = premium
+		components.StatusTier = tier
+		components.AgeGap = ageGap
+	}
 	return compatibility
 }
 
-//func (a *Account) CheckCompatibility(account Account, now int) string {
-//	compatibility := "0"
-//
-//	if account.isPremium(now) {
-//		compatibility = "1"
-//	}
-//
-//	switch account.Status {
-//	case "свободны":
-//		compatibility += "3"
-//	case "всё сложно":
-//		compatibility += "2"
-//	case "заняты":
-//		compatibility += "1"
-//	}
-//
-//	interestsCount := 0
-//	for _, interest := range account.Interests {
-//		if _, ok := a.interestsMap[interest]; ok {
-//			interestsCount++
-//		}
-//	}
-//	if interestsCount < 10 {
-//		compatibility += "0"
-//	}
-//	compatibility += strconv.Itoa(interestsCount)
-//
-//	diff := account.Birth - a.Birth
-//	if diff < 0 {
-//		compatibility += strconv.Itoa(1000000000 + diff)
-//	} else {
-//		compatibility += strconv.Itoa(1000000000 - diff)
-//	}
-//
-//	return compatibility
-//}
+// CompatibilityBound is the highest compatibility an account with the given
+// premium state and status can reach, all interests shared and no age gap
+func (a *Account) CompatibilityBound(premium bool, status string) int {
+	bound := ageCompatibility + len(a.Interests)*interestCompatibility + statusTiers[status]*statusCompatibility
+	if premium {
+		bound += premiumCompatibility
+	}
+	return bound
+}
 
 func (a *Account) isPremium(now int) bool {
 	if a.Premium == nil {
@@ -132,51 +168,3 @@ func (a *Account) isPremium(now int) bool {
 	}
 	return false
 }
-
-func (a *Account) PrepareLikesMap() {
-	a.likesMap = make(map[int][]int)
-	for _, like := range a.Likes {
-		a.likesMap[like.ID] = append(a.likesMap[like.ID], like.TS)
-	}
-}
-
-func (a *Account) CheckSimilarity(account Account) float64 {
-	var similarity float64
-	account.PrepareLikesMap()
-	var avrLikes, avrMyLikes float64
-	for k, likes := range account.likesMap {
-		avrLikes, avrMyLikes = 0, 0
-		if myLikes, ok := a.likesMap[k]; ok {
-			for _, myLike := range myLikes {
-				avrMyLikes += float64(myLike)
-			}
-			avrMyLikes /= float64(len(myLikes))
-
-			for _, like := range likes {
-				avrLikes += float64(like)
-			}
-			avrLikes /= float64(len(likes))
-
-			if avrMyLikes == avrLikes {
-				similarity += 1
-				continue
-			}
-
-			similarity += 1 / math.Abs(avrMyLikes-avrLikes)
-		}
-	}
-	return similarity
-}
-
-func (a *Account) GetNewIds(account Account) []int {
-	var ids []int
-	for _, like := range account.Likes {
-		if _, ok := a.likesMap[like.ID]; !ok {
-			ids = append(ids, like.ID)
-		}
-	}
-	sort.Slice(ids, func(i, j int) bool {
-		return ids[i] > ids[j]
-	})
-	return ids
-}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"math"
+	"time"
+)
+
+// AgeRange bounds the age in full years at the current time, a zero bound is not set
+type AgeRange struct {
+	Min int
+	Max int
+}
+
+// Or returns the range with the unset bounds taken from the fallback
+func (r AgeRange) Or(fallback AgeRange) AgeRange {
+	if r.Min == 0 {
+		r.Min = fallback.Min
+	}
+	if r.Max == 0 {
+		r.Max = fallback.Max
+	}
+	return r
+}
+
+// Births returns the birth timestamps (after, until] matching the range at now
+func (r AgeRange) Births(now int) (int, int) {
+	t := time.Unix(int64(now), 0).UTC()
+	after, until := math.MinInt64, math.MaxInt64
+	if r.Min > 0 {
+		until = int(t.AddDate(-r.Min, 0, 0).Unix())
+	}
+	if r.Max > 0 {
+		after = int(t.AddDate(-r.Max-1, 0, 0).Unix())
+	}
+	return after, until
+}
+
+func (r AgeRange) Contains(birth, now int) bool {
+	after, until := r.Births(now)
+	return birth > after && birth <= until
+}
@@ -0,0 +1,81 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hlc/app/internal/golden"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// fixtureNow is the current time of testdata/accounts.json
+const fixtureNow = 1546300800
+
+func readFixture(t *testing.T) []Account {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "accounts.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixture := Accounts{}
+	err = json.Unmarshal(data, &fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fixture.Accounts
+}
+
+// The fixture has few births, interests and statuses, so the recommendations
+// are full of equal scores ordered by the ids.
+func TestRecommendGolden(t *testing.T) {
+	accounts := readFixture(t)
+	x := NewRecommendIndex()
+	x.SetNow(fixtureNow)
+	for _, account := range accounts {
+		x.Put(account)
+	}
+
+	var got bytes.Buffer
+	for _, name := range []string{DefaultScorer, "jaccard"} {
+		for _, account := range accounts {
+			recommended, err := x.Recommend(account.ID, RecommendQuery{Limit: 10, Scorer: Scorers[name]})
+			if err != nil {
+				t.Fatal(err)
+			}
+			fmt.Fprintln(&got, name, account.ID, ids(recommended))
+		}
+	}
+	golden.Check(t, "recommend.golden", got.Bytes())
+}
+
+// The groups are ordered by the sort field and then by the keys in the requested order.
+func TestGroupGolden(t *testing.T) {
+	accounts := readFixture(t)
+	x := NewGroupIndex()
+	x.SetNow(fixtureNow)
+	for _, account := range accounts {
+		x.Put(account)
+	}
+
+	queries := []GroupQuery{
+		{Keys: []string{"sex"}, SortBy: "count", Order: 1},
+		{Keys: []string{"status", "sex"}, SortBy: "count", Order: -1},
+		{Keys: []string{"sex", "status"}, SortBy: "count", Order: -1},
+		{Keys: []string{"country", "city"}, SortBy: "avg_age", Order: 1, Aggregates: []string{"avg_age"}},
+		{Keys: []string{"interests"}, SortBy: "count", Order: -1, Filter: GroupFilter{Sex: "f"}},
+		{Keys: []string{"premium", "birth_year"}, SortBy: "premium_share", Order: -1,
+			Aggregates: []string{"premium_share", "avg_likes_given", "avg_likes_received"}},
+		{Keys: []string{"joined_year"}, SortBy: "count", Order: 1, Filter: GroupFilter{Interest: "кино"}},
+	}
+	var got bytes.Buffer
+	for _, q := range queries {
+		q.Limit = 10
+		groups, err := json.Marshal(x.Groups(q))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(&got, "%v %s %d %+v\n%s\n", q.Keys, q.SortBy, q.Order, q.Filter, groups)
+	}
+	golden.Check(t, "groups.golden", got.Bytes())
+}
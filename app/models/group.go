@@ -1,14 +1,49 @@
 package models
 
-var Keys = map[string]bool{"sex": true, "status": true, "interests": true, "country": true, "city": true}
+var Keys = map[string]bool{
+	"sex":         true,
+	"status":      true,
+	"interests":   true,
+	"country":     true,
+	"city":        true,
+	"birth_year":  true,
+	"joined_year": true,
+	"premium":     true,
+}
+
+// Aggregates lists the metrics accepted by the aggregates= group parameter
+var Aggregates = map[string]bool{
+	"avg_age":            true,
+	"premium_share":      true,
+	"avg_likes_given":    true,
+	"avg_likes_received": true,
+}
+
+// premium states used by the "premium" group key
+const (
+	PremiumActive  = "active"
+	PremiumExpired = "expired"
+	PremiumNever   = "never" //no premium or a premium window which starts later
+)
+
+// PremiumStates lists the premium states in the order the "premium" group key sorts them
+var PremiumStates = []string{PremiumActive, PremiumExpired, PremiumNever}
 
 type Group struct {
-	Sex       string `json:"sex,omitempty" bson:"sex,omitempty"`
-	Status    string `json:"status,omitempty" bson:"status,omitempty"`
-	Interests string `json:"interests,omitempty" bson:"interests,omitempty"`
-	Country   string `json:"country,omitempty" bson:"country,omitempty"`
-	City      string `json:"city,omitempty" bson:"city,omitempty"`
-	Count     int    `json:"count" bson:"count"`
+	Sex        string `json:"sex,omitempty" bson:"sex,omitempty"`
+	Status     string `json:"status,omitempty" bson:"status,omitempty"`
+	Interests  string `json:"interests,omitempty" bson:"interests,omitempty"`
+	Country    string `json:"country,omitempty" bson:"country,omitempty"`
+	City       string `json:"city,omitempty" bson:"city,omitempty"`
+	BirthYear  int    `json:"birth_year,omitempty" bson:"birth_year,omitempty"`
+	JoinedYear int    `json:"joined_year,omitempty" bson:"joined_year,omitempty"`
+	Premium    string `json:"premium,omitempty" bson:"premium,omitempty"` //active|expired|never
+	Count      int    `json:"count" bson:"count"`
+
+	AvgAge           *float64 `json:"avg_age,omitempty" bson:"avg_age,omitempty"`
+	PremiumShare     *float64 `json:"premium_share,omitempty" bson:"premium_share,omitempty"`
+	AvgLikesGiven    *float64 `json:"avg_likes_given,omitempty" bson:"avg_likes_given,omitempty"`
+	AvgLikesReceived *float64 `json:"avg_likes_received,omitempty" bson:"avg_likes_received,omitempty"`
 }
 
 type Groups struct {
@@ -0,0 +1,431 @@
+package models
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// GroupIndex keeps the running sums of the group aggregates for every
+// combination of the group key values, it is maintained on every account
+// and like change so that the aggregates are not rescanned per request.
+// The interest rows count an account once per interest, like $unwind does.
+type GroupIndex struct {
+	mu        sync.RWMutex
+	now       int
+	accounts  map[int]*groupAccount
+	rows      map[groupRow]*groupSums //rows without the interest
+	interests map[groupRow]*groupSums //rows with the interest
+}
+
+// groupRow is a combination of the group key values
+type groupRow struct {
+	sex        string
+	status     string
+	country    string
+	city       string
+	birthYear  int
+	joinedYear int
+	premium    string //premium state at now
+	interest   string //set in the interest rows only
+}
+
+type groupSums struct {
+	count         int
+	birth         int
+	premium       int //accounts with the premium active at now
+	likesGiven    int
+	likesReceived int
+}
+
+// groupAccount is what the account is counted with
+type groupAccount struct {
+	row           groupRow
+	interests     []string
+	premium       *Premium
+	birth         int
+	likesGiven    int
+	likesReceived int
+}
+
+func NewGroupIndex() *GroupIndex {
+	return &GroupIndex{
+		accounts:  make(map[int]*groupAccount),
+		rows:      make(map[groupRow]*groupSums),
+		interests: make(map[groupRow]*groupSums),
+	}
+}
+
+// SetNow moves the index to the new current time and recounts the rows,
+// since the premium states are checked against it
+func (x *GroupIndex) SetNow(now int) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.now = now
+	x.rows = make(map[groupRow]*groupSums)
+	x.interests = make(map[groupRow]*groupSums)
+	for _, account := range x.accounts {
+		account.row.premium = PremiumState(account.premium, now)
+		x.count(account, 1)
+	}
+}
+
+// Put adds the account to the index or recounts it
+func (x *GroupIndex) Put(account Account) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if old, ok := x.accounts[account.ID]; ok {
+		x.count(old, -1)
+	}
+
+	counted := &groupAccount{
+		row: groupRow{
+			sex:        account.Sex,
+			status:     account.Status,
+			country:    account.Country,
+			city:       account.City,
+			birthYear:  yearOf(account.Birth),
+			joinedYear: yearOf(account.Joined),
+			premium:    PremiumState(account.Premium, x.now),
+		},
+		interests:     append([]string{}, account.Interests...),
+		birth:         account.Birth,
+		likesGiven:    len(account.Likes),
+		likesReceived: account.LikesReceived,
+	}
+	if account.Premium != nil {
+		premium := *account.Premium
+		counted.premium = &premium
+	}
+	x.accounts[account.ID] = counted
+	x.count(counted, 1)
+}
+
+// Subset returns an index of the accounts with the ids, it answers the
+// filters the rows do not keep, such as the likers of an account
+func (x *GroupIndex) Subset(ids []int) *GroupIndex {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	subset := NewGroupIndex()
+	subset.now = x.now
+	for _, id := range ids {
+		account, ok := x.accounts[id]
+		if !ok {
+			continue
+		}
+		if _, ok := subset.accounts[id]; ok {
+			continue
+		}
+		counted := *account
+		subset.accounts[id] = &counted
+		subset.count(&counted, 1)
+	}
+	return subset
+}
+
+// AddLikes counts the likes set by the liker and received by their likees
+func (x *GroupIndex) AddLikes(likerID int, likes []Like) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.addLikes(likerID, len(likes), 0)
+	for _, like := range likes {
+		x.addLikes(like.ID, 0, 1)
+	}
+}
+
+// AddReceived counts the like received by the account
+func (x *GroupIndex) AddReceived(id int) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.addLikes(id, 0, 1)
+}
+
+func (x *GroupIndex) addLikes(id, given, received int) {
+	account, ok := x.accounts[id]
+	if !ok {
+		return
+	}
+	x.count(account, -1)
+	account.likesGiven += given
+	account.likesReceived += received
+	x.count(account, 1)
+}
+
+func (x *GroupIndex) count(account *groupAccount, sign int) {
+	addSums(x.rows, account.row, account, sign)
+	for _, interest := range account.interests {
+		row := account.row
+		row.interest = interest
+		addSums(x.interests, row, account, sign)
+	}
+}
+
+func addSums(rows map[groupRow]*groupSums, row groupRow, account *groupAccount, sign int) {
+	sums, ok := rows[row]
+	if !ok {
+		sums = &groupSums{}
+		rows[row] = sums
+	}
+	sums.count += sign
+	sums.birth += sign * account.birth
+	if row.premium == PremiumActive {
+		sums.premium += sign
+	}
+	sums.likesGiven += sign * account.likesGiven
+	sums.likesReceived += sign * account.likesReceived
+	if sums.count == 0 {
+		delete(rows, row)
+	}
+}
+
+// GroupFilter holds the filters the group index can answer, zero values are not set
+type GroupFilter struct {
+	Sex        string
+	Status     string
+	Country    string
+	City       string
+	BirthYear  int
+	JoinedYear int
+	Interest   string
+}
+
+func (f GroupFilter) match(row groupRow) bool {
+	return (f.Sex == "" || row.sex == f.Sex) &&
+		(f.Status == "" || row.status == f.Status) &&
+		(f.Country == "" || row.country == f.Country) &&
+		(f.City == "" || row.city == f.City) &&
+		(f.BirthYear == 0 || row.birthYear == f.BirthYear) &&
+		(f.JoinedYear == 0 || row.joinedYear == f.JoinedYear) &&
+		(f.Interest == "" || row.interest == f.Interest)
+}
+
+// GroupQuery holds the parameters of GroupIndex.Groups
+type GroupQuery struct {
+	Filter     GroupFilter
+	Keys       []string
+	Aggregates []string
+	SortBy     string //count or one of the aggregates
+	Order      int    //1 or -1
+	Limit      int
+}
+
+// Groups rolls the rows matching the filter up by the keys and returns
+// up to limit groups ordered by the sort field and then by the keys in the
+// order they were requested. The filter by interest and the interests key
+// can not be combined.
+func (x *GroupIndex) Groups(q GroupQuery) []Group {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	rows := x.rows
+	if q.Filter.Interest != "" || contains(q.Keys, "interests") {
+		rows = x.interests
+	}
+
+	rolled := make(map[groupRow]*groupSums)
+	for row, sums := range rows {
+		if !q.Filter.match(row) {
+			continue
+		}
+		key := rollUp(row, q.Keys)
+		total, ok := rolled[key]
+		if !ok {
+			total = &groupSums{}
+			rolled[key] = total
+		}
+		total.count += sums.count
+		total.birth += sums.birth
+		total.premium += sums.premium
+		total.likesGiven += sums.likesGiven
+		total.likesReceived += sums.likesReceived
+	}
+
+	groups := make([]Group, 0, len(rolled))
+	for row, sums := range rolled {
+		groups = append(groups, x.group(row, sums, q.Aggregates))
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groupBefore(&groups[i], &groups[j], q)
+	})
+	if len(groups) > q.Limit {
+		groups = groups[:q.Limit]
+	}
+	return groups
+}
+
+// rollUp keeps the values of the keys in the row
+func rollUp(row groupRow, keys []string) groupRow {
+	key := groupRow{}
+	for _, k := range keys {
+		switch k {
+		case "sex":
+			key.sex = row.sex
+		case "status":
+			key.status = row.status
+		case "country":
+			key.country = row.country
+		case "city":
+			key.city = row.city
+		case "birth_year":
+			key.birthYear = row.birthYear
+		case "joined_year":
+			key.joinedYear = row.joinedYear
+		case "premium":
+			key.premium = row.premium
+		case "interests":
+			key.interest = row.interest
+		}
+	}
+	return key
+}
+
+func (x *GroupIndex) group(row groupRow, sums *groupSums, aggregates []string) Group {
+	group := Group{
+		Sex:        row.sex,
+		Status:     row.status,
+		Interests:  row.interest,
+		Country:    row.country,
+		City:       row.city,
+		BirthYear:  row.birthYear,
+		JoinedYear: row.joinedYear,
+		Premium:    row.premium,
+		Count:      sums.count,
+	}
+	count := float64(sums.count)
+	for _, aggregate := range aggregates {
+		var value float64
+		switch aggregate {
+		case "avg_age":
+			value = (float64(x.now) - float64(sums.birth)/count) / SecondsPerYear
+			group.AvgAge = &value
+		case "premium_share":
+			value = float64(sums.premium) / count
+			group.PremiumShare = &value
+		case "avg_likes_given":
+			value = float64(sums.likesGiven) / count
+			group.AvgLikesGiven = &value
+		case "avg_likes_received":
+			value = float64(sums.likesReceived) / count
+			group.AvgLikesReceived = &value
+		}
+	}
+	return group
+}
+
+func groupBefore(x, y *Group, q GroupQuery) bool {
+	if c := compareFloats(x.value(q.SortBy), y.value(q.SortBy)); c != 0 {
+		return c*q.Order < 0
+	}
+	for _, key := range q.Keys {
+		if c := x.compareKey(y, key); c != 0 {
+			return c*q.Order < 0
+		}
+	}
+	return false
+}
+
+// value returns the count or the aggregate of the group
+func (g *Group) value(name string) float64 {
+	var value *float64
+	switch name {
+	case "avg_age":
+		value = g.AvgAge
+	case "premium_share":
+		value = g.PremiumShare
+	case "avg_likes_given":
+		value = g.AvgLikesGiven
+	case "avg_likes_received":
+		value = g.AvgLikesReceived
+	default:
+		return float64(g.Count)
+	}
+	if value == nil {
+		return 0
+	}
+	return *value
+}
+
+func (g *Group) compareKey(other *Group, key string) int {
+	switch key {
+	case "sex":
+		return compareStrings(g.Sex, other.Sex)
+	case "status":
+		return compareStrings(g.Status, other.Status)
+	case "interests":
+		return compareStrings(g.Interests, other.Interests)
+	case "country":
+		return compareStrings(g.Country, other.Country)
+	case "city":
+		return compareStrings(g.City, other.City)
+	case "birth_year":
+		return compareFloats(float64(g.BirthYear), float64(other.BirthYear))
+	case "joined_year":
+		return compareFloats(float64(g.JoinedYear), float64(other.JoinedYear))
+	case "premium":
+		return compareFloats(float64(PremiumRank(g.Premium)), float64(PremiumRank(other.Premium)))
+	}
+	return 0
+}
+
+func compareStrings(x, y string) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+func compareFloats(x, y float64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// PremiumState returns the state of the premium window at now
+// the way the "premium" group key reports it, a window which has not
+// started yet has never been active
+func PremiumState(premium *Premium, now int) string {
+	switch {
+	case premium == nil:
+		return PremiumNever
+	case premium.Start < now && premium.Finish > now:
+		return PremiumActive
+	case premium.Finish <= now:
+		return PremiumExpired
+	}
+	return PremiumNever
+}
+
+// PremiumRank is the position of the state in PremiumStates
+func PremiumRank(state string) int {
+	for i, s := range PremiumStates {
+		if s == state {
+			return i
+		}
+	}
+	return len(PremiumStates)
+}
+
+func yearOf(ts int) int {
+	return time.Unix(int64(ts), 0).UTC().Year()
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,186 @@
+package models
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestGroupIndexFollowsMutations(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	accounts := make([]Account, 200)
+	for i := range accounts {
+		accounts[i] = randomGroupAccount(random, i+1)
+	}
+
+	x := NewGroupIndex()
+	x.SetNow(testNow)
+	for _, account := range accounts {
+		x.Put(account)
+	}
+
+	//updates, likes and a new current time applied to the index
+	//must end in the same sums as counting the final accounts afresh
+	for i := 0; i < 50; i++ {
+		id := random.Intn(len(accounts)) + 1
+		updated := randomGroupAccount(random, id)
+		updated.Likes = accounts[id-1].Likes
+		updated.LikesReceived = accounts[id-1].LikesReceived
+		accounts[id-1] = updated
+		x.Put(updated)
+	}
+	for i := 0; i < 100; i++ {
+		liker := random.Intn(len(accounts)) + 1
+		likes := []Like{{ID: random.Intn(len(accounts)) + 1, TS: i}}
+		accounts[liker-1].Likes = append(accounts[liker-1].Likes, likes...)
+		accounts[likes[0].ID-1].LikesReceived++
+		x.AddLikes(liker, likes)
+	}
+	x.SetNow(testNow + 50*24*60*60)
+
+	want := NewGroupIndex()
+	want.SetNow(testNow + 50*24*60*60)
+	for _, account := range accounts {
+		want.Put(account)
+	}
+
+	aggregates := []string{"avg_age", "premium_share", "avg_likes_given", "avg_likes_received"}
+	queries := []GroupQuery{
+		{Keys: []string{"sex"}, SortBy: "count", Order: 1},
+		{Keys: []string{"status", "premium"}, SortBy: "premium_share", Order: -1},
+		{Keys: []string{"interests"}, SortBy: "avg_age", Order: 1},
+		{Keys: []string{"city"}, SortBy: "avg_likes_received", Order: -1, Filter: GroupFilter{Sex: "f"}},
+		{Keys: []string{"birth_year"}, SortBy: "avg_likes_given", Order: 1, Filter: GroupFilter{Interest: "b"}},
+	}
+	for _, q := range queries {
+		q.Aggregates = aggregates
+		q.Limit = 50
+		got, expected := x.Groups(q), want.Groups(q)
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("groups %v = %v, want %v", q.Keys, got, expected)
+		}
+	}
+}
+
+func TestGroupIndexAggregates(t *testing.T) {
+	x := NewGroupIndex()
+	x.SetNow(testNow)
+	x.Put(Account{ID: 1, Sex: "m", Birth: testNow - 20*yearSeconds, Interests: []string{"a"},
+		Premium: &Premium{Start: testNow - 10, Finish: testNow + 10}, Likes: []Like{{ID: 2}, {ID: 3}}})
+	x.Put(Account{ID: 2, Sex: "m", Birth: testNow - 30*yearSeconds, Interests: []string{"a", "b"},
+		Premium: &Premium{Start: testNow + 10, Finish: testNow + 20}, LikesReceived: 1})
+	x.Put(Account{ID: 3, Sex: "f", Birth: testNow - 40*yearSeconds, LikesReceived: 1})
+
+	groups := x.Groups(GroupQuery{
+		Keys:       []string{"sex"},
+		Aggregates: []string{"avg_age", "premium_share", "avg_likes_given", "avg_likes_received"},
+		SortBy:     "avg_age",
+		Order:      -1,
+		Limit:      10,
+	})
+	if len(groups) != 2 || groups[0].Sex != "f" || groups[1].Sex != "m" {
+		t.Fatalf("groups = %+v", groups)
+	}
+	m := groups[1]
+	if m.Count != 2 || *m.AvgAge != 25 || *m.PremiumShare != 0.5 || *m.AvgLikesGiven != 1 || *m.AvgLikesReceived != 0.5 {
+		t.Errorf("m = %+v avg_age=%v premium_share=%v avg_likes_given=%v avg_likes_received=%v",
+			m, *m.AvgAge, *m.PremiumShare, *m.AvgLikesGiven, *m.AvgLikesReceived)
+	}
+
+	interests := x.Groups(GroupQuery{Keys: []string{"interests"}, SortBy: "count", Order: -1, Limit: 10})
+	if len(interests) != 2 || interests[0].Interests != "a" || interests[0].Count != 2 || interests[1].Count != 1 {
+		t.Errorf("interests = %+v", interests)
+	}
+}
+
+func TestGroupIndexPremiumOrder(t *testing.T) {
+	x := NewGroupIndex()
+	x.SetNow(testNow)
+	premiums := []*Premium{
+		nil,
+		{Start: testNow + 10, Finish: testNow + 20}, //starts later, counted as never
+		{Start: testNow - 20, Finish: testNow - 10},
+		{Start: testNow - 10, Finish: testNow + 10},
+	}
+	for i, premium := range premiums {
+		x.Put(Account{ID: i + 1, Premium: premium})
+	}
+	//one account per state besides never, the ties go by the state order
+	x.Put(Account{ID: 5, Premium: &Premium{Start: testNow - 20, Finish: testNow - 10}})
+	x.Put(Account{ID: 6, Premium: &Premium{Start: testNow - 10, Finish: testNow + 10}})
+
+	for _, order := range []int{1, -1} {
+		groups := x.Groups(GroupQuery{Keys: []string{"premium"}, SortBy: "count", Order: order, Limit: 10})
+		got := make([]string, len(groups))
+		for i, group := range groups {
+			got[i] = group.Premium
+			if group.Count != 2 {
+				t.Errorf("order %d: %s count = %d, want 2", order, group.Premium, group.Count)
+			}
+		}
+		want := []string{PremiumActive, PremiumExpired, PremiumNever}
+		if order < 0 {
+			want = []string{PremiumNever, PremiumExpired, PremiumActive}
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("order %d: premium groups = %v, want %v", order, got, want)
+		}
+	}
+}
+
+func TestGroupIndexSubset(t *testing.T) {
+	random := rand.New(rand.NewSource(2))
+	x := NewGroupIndex()
+	x.SetNow(testNow)
+	want := NewGroupIndex()
+	want.SetNow(testNow)
+	ids := make([]int, 0)
+	for id := 1; id <= 100; id++ {
+		account := randomGroupAccount(random, id)
+		account.Likes = make([]Like, random.Intn(5))
+		account.LikesReceived = random.Intn(5)
+		x.Put(account)
+		if id%3 == 0 {
+			want.Put(account)
+			//the ids repeat like the likers of an account liked twice
+			ids = append(ids, id, id)
+		}
+	}
+	//an unknown id is left out
+	ids = append(ids, 1000)
+
+	subset := x.Subset(ids)
+	q := GroupQuery{
+		Keys:       []string{"sex", "premium"},
+		Aggregates: []string{"avg_age", "premium_share", "avg_likes_given", "avg_likes_received"},
+		SortBy:     "avg_likes_given",
+		Order:      -1,
+		Limit:      10,
+	}
+	if got, expected := subset.Groups(q), want.Groups(q); !reflect.DeepEqual(got, expected) {
+		t.Errorf("subset groups = %v, want %v", got, expected)
+	}
+}
+
+const yearSeconds = int(SecondsPerYear)
+
+func randomGroupAccount(random *rand.Rand, id int) Account {
+	account := Account{
+		ID:     id,
+		Sex:    []string{"m", "f"}[random.Intn(2)],
+		Status: Statuses[random.Intn(len(Statuses))],
+		City:   []string{"", "x", "y"}[random.Intn(3)],
+		Birth:  testNow - (18+random.Intn(30))*yearSeconds,
+		Joined: testNow - random.Intn(5)*yearSeconds,
+	}
+	for _, interest := range []string{"a", "b", "c"} {
+		if random.Intn(2) == 0 {
+			account.Interests = append(account.Interests, interest)
+		}
+	}
+	if random.Intn(2) == 0 {
+		start := testNow + (random.Intn(100)-50)*24*60*60
+		account.Premium = &Premium{Start: start, Finish: start + 30*24*60*60}
+	}
+	return account
+}
@@ -0,0 +1,33 @@
+package models
+
+import "sort"
+
+// IDSet is a sorted slice of unique account ids
+type IDSet []int
+
+// Contains reports whether the id is in the set using binary search
+func (s IDSet) Contains(id int) bool {
+	i := sort.SearchInts(s, id)
+	return i < len(s) && s[i] == id
+}
+
+// Insert adds the id to the set
+func (s IDSet) Insert(id int) IDSet {
+	i := sort.SearchInts(s, id)
+	if i < len(s) && s[i] == id {
+		return s
+	}
+	s = append(s, 0)
+	copy(s[i+1:], s[i:])
+	s[i] = id
+	return s
+}
+
+// Remove deletes the id from the set
+func (s IDSet) Remove(id int) IDSet {
+	i := sort.SearchInts(s, id)
+	if i == len(s) || s[i] != id {
+		return s
+	}
+	return append(s[:i], s[i+1:]...)
+}
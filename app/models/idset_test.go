@@ -0,0 +1,32 @@
+package models
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestIDSetMatchesMap(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	var s IDSet
+	m := make(map[int]bool)
+	for i := 0; i < 2000; i++ {
+		id := random.Intn(100)
+		if random.Intn(3) == 0 {
+			s = s.Remove(id)
+			delete(m, id)
+		} else {
+			s = s.Insert(id)
+			m[id] = true
+		}
+	}
+
+	if !sort.IntsAreSorted(s) || len(s) != len(m) {
+		t.Fatalf("set %v, want the %d ids sorted", s, len(m))
+	}
+	for id := -1; id <= 100; id++ {
+		if s.Contains(id) != m[id] {
+			t.Errorf("contains %d = %v, want %v", id, s.Contains(id), m[id])
+		}
+	}
+}
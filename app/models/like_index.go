@@ -0,0 +1,277 @@
+package models
+
+import (
+	"sort"
+	"sync"
+)
+
+// LikeIndex keeps the like graph in both directions,
+// it is maintained on every like insert
+type LikeIndex struct {
+	mu      sync.RWMutex
+	vectors map[int]LikeVector //liker id -> likes by likee id
+	likers  map[int][]Like     //likee id -> likes with the liker id in the likeBefore order
+	version map[int]int        //liker id -> number of like batches added
+}
+
+func NewLikeIndex() *LikeIndex {
+	return &LikeIndex{
+		vectors: make(map[int]LikeVector),
+		likers:  make(map[int][]Like),
+		version: make(map[int]int),
+	}
+}
+
+// Add indexes the likes set by the liker
+func (x *LikeIndex) Add(likerID int, likes []Like) {
+	x.AddAccounts([]Account{{ID: likerID, Likes: likes}})
+}
+
+// AddAccounts indexes the likes set by the accounts. The likes received by
+// every likee are sorted once per call and merged into its list, so a batch
+// costs linear time in the lists it touches instead of a sorted insert per like.
+func (x *LikeIndex) AddAccounts(accounts []Account) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	received := make(map[int][]Like)
+	for _, account := range accounts {
+		x.vectors[account.ID] = x.vectors[account.ID].Add(account.Likes)
+		x.version[account.ID]++
+		for _, like := range account.Likes {
+			received[like.ID] = append(received[like.ID], Like{ID: account.ID, TS: like.TS})
+		}
+	}
+	for likee, likes := range received {
+		sortLikes(likes)
+		x.likers[likee] = mergeLikes(x.likers[likee], likes)
+	}
+}
+
+// Missing returns the likes of the liker which are not indexed yet, likes
+// with the same likee and timestamp are the same like
+func (x *LikeIndex) Missing(likerID int, likes []Like) []Like {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	seen := make(map[Like]bool, len(likes))
+	missing := make([]Like, 0)
+	for _, like := range likes {
+		if seen[like] {
+			continue
+		}
+		seen[like] = true
+
+		//the likers of the likee are searched for the like with the liker as id
+		likers := x.likers[like.ID]
+		received := Like{ID: likerID, TS: like.TS}
+		i := sort.Search(len(likers), func(i int) bool {
+			return !likeBefore(likers[i], received)
+		})
+		if i == len(likers) || likers[i] != received {
+			missing = append(missing, like)
+		}
+	}
+	return missing
+}
+
+// LikeIndexEntry is the part of the like index kept for one account,
+// it is the form the index is stored in the snapshot
+type LikeIndexEntry struct {
+	ID      int
+	Vector  LikeVector //likes set by the account
+	Likers  []Like     //likes received by the account
+	Version int
+}
+
+// Entries passes the entries of all indexed accounts to fn,
+// the index is not changed until it returns
+func (x *LikeIndex) Entries(fn func(entry LikeIndexEntry) error) error {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	for id, version := range x.version {
+		err := fn(LikeIndexEntry{ID: id, Vector: x.vectors[id], Likers: x.likers[id], Version: version})
+		if err != nil {
+			return err
+		}
+	}
+	//the accounts which only received likes
+	for id, likers := range x.likers {
+		if _, ok := x.version[id]; ok {
+			continue
+		}
+		err := fn(LikeIndexEntry{ID: id, Likers: likers})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Restore puts back the entries passed by Entries
+func (x *LikeIndex) Restore(entries []LikeIndexEntry) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	for _, entry := range entries {
+		if entry.Version > 0 {
+			x.vectors[entry.ID] = entry.Vector
+			x.version[entry.ID] = entry.Version
+		}
+		if len(entry.Likers) > 0 {
+			x.likers[entry.ID] = entry.Likers
+		}
+	}
+}
+
+// Version changes every time the account likes are added
+func (x *LikeIndex) Version(id int) int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	return x.version[id]
+}
+
+// Vector returns the likes set by the account
+func (x *LikeIndex) Vector(id int) LikeVector {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	return x.vectors[id]
+}
+
+// Candidates returns the sorted ids of the accounts which liked
+// any of the accounts liked by the account
+func (x *LikeIndex) Candidates(id int) []int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	ids := make([]int, 0)
+	for _, entry := range x.vectors[id] {
+		for _, liker := range x.likers[entry.ID] {
+			if liker.ID != id {
+				ids = append(ids, liker.ID)
+			}
+		}
+	}
+	sort.Ints(ids)
+
+	unique := ids[:0]
+	for i, candidateID := range ids {
+		if i == 0 || candidateID != ids[i-1] {
+			unique = append(unique, candidateID)
+		}
+	}
+	return unique
+}
+
+// Likers returns up to limit likes received by the account, newest first.
+// The page starts after the like with beforeTS and beforeID,
+// zero beforeTS starts from the newest like and zero beforeID from the newest
+// like older than beforeTS.
+func (x *LikeIndex) Likers(id, beforeTS, beforeID, limit int) []Like {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	likers := x.likers[id]
+	start := 0
+	if beforeTS != 0 {
+		cursor := Like{ID: beforeID, TS: beforeTS}
+		start = sort.Search(len(likers), func(i int) bool {
+			return likeBefore(cursor, likers[i])
+		})
+	}
+
+	end := start + limit
+	if end > len(likers) {
+		end = len(likers)
+	}
+	return append([]Like{}, likers[start:end]...)
+}
+
+// Received returns the number of likes the account got
+func (x *LikeIndex) Received(id int) int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	return len(x.likers[id])
+}
+
+// Matches returns the accounts which liked the account back, the like
+// timestamp is the latest like of the pair. Matches are ordered by
+// the timestamp, the newest first, and then by id descending.
+func (x *LikeIndex) Matches(id int) []Like {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	received := latestLikes(x.likers[id])
+	matches := make([]Like, 0)
+	for _, entry := range x.vectors[id] {
+		if likedBack, ok := received[entry.ID]; ok {
+			ts := entry.Latest
+			if likedBack > ts {
+				ts = likedBack
+			}
+			matches = append(matches, Like{ID: entry.ID, TS: ts})
+		}
+	}
+	sortLikes(matches)
+	return matches
+}
+
+// latestLikes maps the ids of the likes to their latest timestamp
+func latestLikes(likes []Like) map[int]int {
+	latest := make(map[int]int, len(likes))
+	for _, like := range likes {
+		if ts, ok := latest[like.ID]; !ok || like.TS > ts {
+			latest[like.ID] = like.TS
+		}
+	}
+	return latest
+}
+
+// likeBefore orders the likes by timestamp and then by id, both descending
+func likeBefore(x, y Like) bool {
+	if x.TS != y.TS {
+		return x.TS > y.TS
+	}
+	return x.ID > y.ID
+}
+
+func sortLikes(likes []Like) {
+	sort.Slice(likes, func(i, j int) bool {
+		return likeBefore(likes[i], likes[j])
+	})
+}
+
+// mergeLikes merges two lists kept in the likeBefore order, a like
+// equal to one of likes goes after it
+func mergeLikes(likes, added []Like) []Like {
+	if len(likes) == 0 {
+		return added
+	}
+	merged := make([]Like, 0, len(likes)+len(added))
+	i, j := 0, 0
+	for i < len(likes) && j < len(added) {
+		if likeBefore(added[j], likes[i]) {
+			merged = append(merged, added[j])
+			j++
+		} else {
+			merged = append(merged, likes[i])
+			i++
+		}
+	}
+	merged = append(merged, likes[i:]...)
+	return append(merged, added[j:]...)
+}
+
+// LikedAccount is an account with the timestamp of its like
+type LikedAccount struct {
+	Account
+	TS int `json:"ts"`
+}
+
+type LikedAccounts struct {
+	Accounts []LikedAccount `json:"accounts"`
+}
@@ -0,0 +1,119 @@
+package models
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestLikeIndexBatchesKeepLikerOrder(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	accounts := make([]Account, 300)
+	want := make(map[int][]Like)
+	for i := range accounts {
+		accounts[i].ID = i + 1
+		for j := random.Intn(20); j > 0; j-- {
+			//few likees and timestamps so that the ties are frequent
+			like := Like{ID: random.Intn(10) + 1, TS: random.Intn(50)}
+			accounts[i].Likes = append(accounts[i].Likes, like)
+			want[like.ID] = append(want[like.ID], Like{ID: accounts[i].ID, TS: like.TS})
+		}
+	}
+
+	x := NewLikeIndex()
+	for start := 0; start < len(accounts); {
+		end := start + 1 + random.Intn(40)
+		if end > len(accounts) {
+			end = len(accounts)
+		}
+		if end-start == 1 {
+			x.Add(accounts[start].ID, accounts[start].Likes)
+		} else {
+			x.AddAccounts(accounts[start:end])
+		}
+		start = end
+	}
+
+	for likee, likes := range want {
+		sortLikes(likes)
+		if got := x.Likers(likee, 0, 0, len(likes)); !reflect.DeepEqual(got, likes) {
+			t.Errorf("likers of %d = %v, want %v", likee, got, likes)
+		}
+		if got := x.Received(likee); got != len(likes) {
+			t.Errorf("received of %d = %d, want %d", likee, got, len(likes))
+		}
+	}
+	for _, account := range accounts {
+		got, want := x.Vector(account.ID), NewLikeVector(account.Likes)
+		if len(got) != len(want) || len(want) > 0 && !reflect.DeepEqual(got, want) {
+			t.Errorf("vector of %d = %v, want %v", account.ID, got, want)
+		}
+	}
+}
+
+func TestLikeIndexMissing(t *testing.T) {
+	x := NewLikeIndex()
+	x.Add(1, []Like{{ID: 2, TS: 10}, {ID: 3, TS: 20}})
+	x.Add(4, []Like{{ID: 2, TS: 10}, {ID: 2, TS: 30}})
+
+	likes := []Like{
+		{ID: 2, TS: 10}, //indexed
+		{ID: 2, TS: 30}, //indexed for another liker
+		{ID: 3, TS: 20}, //indexed
+		{ID: 3, TS: 21},
+		{ID: 3, TS: 21}, //repeated in the batch
+		{ID: 5, TS: 10}, //likee without likers
+	}
+	want := []Like{{ID: 2, TS: 30}, {ID: 3, TS: 21}, {ID: 5, TS: 10}}
+	if got := x.Missing(1, likes); !reflect.DeepEqual(got, want) {
+		t.Errorf("missing = %v, want %v", got, want)
+	}
+}
+
+// The matches follow the likes added after the first batch and are checked
+// against the pairs found over all the likes.
+func TestLikeIndexMatches(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	x := NewLikeIndex()
+	latest := make(map[[2]int]int) //liker, likee -> latest like
+	for batch := 0; batch < 20; batch++ {
+		accounts := make([]Account, 0)
+		for i := 0; i < 10; i++ {
+			account := Account{ID: random.Intn(30) + 1}
+			for j := random.Intn(5); j > 0; j-- {
+				like := Like{ID: random.Intn(30) + 1, TS: random.Intn(100)}
+				if like.ID == account.ID {
+					continue
+				}
+				account.Likes = append(account.Likes, like)
+				if ts, ok := latest[[2]int{account.ID, like.ID}]; !ok || like.TS > ts {
+					latest[[2]int{account.ID, like.ID}] = like.TS
+				}
+			}
+			accounts = append(accounts, account)
+		}
+		x.AddAccounts(accounts)
+	}
+
+	for id := 1; id <= 30; id++ {
+		want := make([]Like, 0)
+		for other := 1; other <= 30; other++ {
+			given, ok := latest[[2]int{id, other}]
+			if !ok {
+				continue
+			}
+			received, ok := latest[[2]int{other, id}]
+			if !ok {
+				continue
+			}
+			if received > given {
+				given = received
+			}
+			want = append(want, Like{ID: other, TS: given})
+		}
+		sortLikes(want)
+		if got := x.Matches(id); !reflect.DeepEqual(got, want) {
+			t.Errorf("matches of %d = %v, want %v", id, got, want)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package models
+
+import (
+	"math"
+	"sort"
+)
+
+// LikeEntry aggregates the likes of one account to the likee
+type LikeEntry struct {
+	ID     int //likee id
+	Sum    int //sum of the like timestamps
+	Count  int
+	Latest int //latest like timestamp
+}
+
+func (e LikeEntry) AvgTS() float64 {
+	return float64(e.Sum) / float64(e.Count)
+}
+
+// LikeVector is a sparse vector of the likes ordered by likee id.
+// A vector is never modified in place, Add returns a new one,
+// so a vector may be read while the index is updated.
+type LikeVector []LikeEntry
+
+func NewLikeVector(likes []Like) LikeVector {
+	sorted := make([]Like, len(likes))
+	copy(sorted, likes)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].ID < sorted[j].ID
+	})
+
+	v := make(LikeVector, 0, len(sorted))
+	for _, like := range sorted {
+		last := len(v) - 1
+		if last >= 0 && v[last].ID == like.ID {
+			v[last].Sum += like.TS
+			v[last].Count++
+			if like.TS > v[last].Latest {
+				v[last].Latest = like.TS
+			}
+			continue
+		}
+		v = append(v, LikeEntry{ID: like.ID, Sum: like.TS, Count: 1, Latest: like.TS})
+	}
+	return v
+}
+
+// Add returns the vector with the likes merged in
+func (v LikeVector) Add(likes []Like) LikeVector {
+	o := NewLikeVector(likes)
+	merged := make(LikeVector, 0, len(v)+len(o))
+	i, j := 0, 0
+	for i < len(v) && j < len(o) {
+		switch {
+		case v[i].ID < o[j].ID:
+			merged = append(merged, v[i])
+			i++
+		case v[i].ID > o[j].ID:
+			merged = append(merged, o[j])
+			j++
+		default:
+			entry := v[i]
+			entry.Sum += o[j].Sum
+			entry.Count += o[j].Count
+			if o[j].Latest > entry.Latest {
+				entry.Latest = o[j].Latest
+			}
+			merged = append(merged, entry)
+			i++
+			j++
+		}
+	}
+	merged = append(merged, v[i:]...)
+	return append(merged, o[j:]...)
+}
+
+// Similarity sums 1/|avgTS1-avgTS2| over the likees of both vectors,
+// a likee liked at the same average time adds 1
+func (v LikeVector) Similarity(o LikeVector) float64 {
+	var similarity float64
+	v.join(o, func(x, y LikeEntry) {
+		similarity += pairSimilarity(x, y)
+	})
+	return similarity
+}
+
+// join calls f for every likee of both vectors
+func (v LikeVector) join(o LikeVector, f func(x, y LikeEntry)) {
+	i, j := 0, 0
+	for i < len(v) && j < len(o) {
+		switch {
+		case v[i].ID < o[j].ID:
+			i++
+		case v[i].ID > o[j].ID:
+			j++
+		default:
+			f(v[i], o[j])
+			i++
+			j++
+		}
+	}
+}
+
+func pairSimilarity(x, y LikeEntry) float64 {
+	diff := math.Abs(x.AvgTS() - y.AvgTS())
+	if diff == 0 {
+		return 1
+	}
+	return 1 / diff
+}
+
+// Missing returns the likee ids of o which are not in v, descending
+func (v LikeVector) Missing(o LikeVector) []int {
+	ids := make([]int, 0)
+	i := len(v) - 1
+	for j := len(o) - 1; j >= 0; j-- {
+		for i >= 0 && v[i].ID > o[j].ID {
+			i--
+		}
+		if i >= 0 && v[i].ID == o[j].ID {
+			continue
+		}
+		ids = append(ids, o[j].ID)
+	}
+	return ids
+}
@@ -0,0 +1,419 @@
+package models
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"sync"
+)
+
+var ErrAccountNotFound = errors.New("account not found")
+
+// bucketStatuses lists the bucket statuses from the most to the least compatible,
+// the empty status collects the accounts with a status not listed in Statuses
+var bucketStatuses = append(append([]string{}, Statuses...), "")
+
+type bucketKey struct {
+	sex     string
+	premium bool //premium is active at the index time
+	status  string
+}
+
+type birthEntry struct {
+	birth int
+	id    int
+}
+
+// RecommendIndex keeps the recommend candidates in buckets keyed by
+// (sex, premium-now, status), every bucket maps an interest to the sorted
+// ids of the accounts having it. Accounts are reindexed by Put when their
+// premium, status or interests change and the buckets are rebuilt by SetNow.
+// The birth range index keeps the accounts of every sex sorted by birth,
+// the added accounts wait in pending until the next query merges them.
+type RecommendIndex struct {
+	mu       sync.RWMutex
+	now      int
+	accounts map[int]*Account
+	buckets  map[bucketKey]map[string]IDSet
+	liked    map[int]IDSet //ids liked by the account
+	blocked  map[int]IDSet //ids the account never gets recommended
+
+	birthsMu sync.Mutex              //guards the merge of pending under the read lock
+	births   map[string][]birthEntry //sex -> accounts sorted by birth and id
+	pending  map[string][]birthEntry //sex -> accounts not merged into births yet
+}
+
+func NewRecommendIndex() *RecommendIndex {
+	return &RecommendIndex{
+		accounts: make(map[int]*Account),
+		buckets:  make(map[bucketKey]map[string]IDSet),
+		liked:    make(map[int]IDSet),
+		blocked:  make(map[int]IDSet),
+		births:   make(map[string][]birthEntry),
+		pending:  make(map[string][]birthEntry),
+	}
+}
+
+// RecommendQuery holds the parameters of RecommendIndex.Recommend
+type RecommendQuery struct {
+	Limit        int
+	Scorer       Scorer
+	ExcludeLiked bool               //skip the accounts already liked
+	Ages         AgeRange           //overrides the stored age preference of the account
+	Accept       func(Account) bool //optional candidate filter
+}
+
+// SetNow moves the index to the new current time and rebuilds the buckets,
+// since the premium windows are checked against it
+func (x *RecommendIndex) SetNow(now int) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.now = now
+	x.buckets = make(map[bucketKey]map[string]IDSet)
+	for _, account := range x.accounts {
+		x.index(account)
+	}
+}
+
+// Put adds the account to the index or reindexes it
+func (x *RecommendIndex) Put(account Account) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if old, ok := x.accounts[account.ID]; ok {
+		x.unindex(old)
+	}
+	x.index(x.store(account))
+}
+
+// Load adds the account like Put but leaves it out of the buckets,
+// which are put back by RestorePostings
+func (x *RecommendIndex) Load(account Account) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.store(account)
+}
+
+// store keeps the indexed fields, likes and blocks of the account
+func (x *RecommendIndex) store(account Account) *Account {
+	stored := Account{
+		ID:        account.ID,
+		Email:     account.Email,
+		FName:     account.FName,
+		SName:     account.SName,
+		Sex:       account.Sex,
+		Birth:     account.Birth,
+		Country:   account.Country,
+		City:      account.City,
+		Status:    account.Status,
+		Interests: append([]string{}, account.Interests...),
+		AgeMin:    account.AgeMin,
+		AgeMax:    account.AgeMax,
+	}
+	if account.Premium != nil {
+		premium := *account.Premium
+		stored.Premium = &premium
+	}
+
+	old, ok := x.accounts[stored.ID]
+	if ok && (old.Birth != stored.Birth || old.Sex != stored.Sex) {
+		x.removeBirth(old)
+	}
+	if !ok || old.Birth != stored.Birth || old.Sex != stored.Sex {
+		x.pending[stored.Sex] = append(x.pending[stored.Sex], birthEntry{birth: stored.Birth, id: stored.ID})
+	}
+	x.accounts[stored.ID] = &stored
+
+	liked := make(IDSet, 0, len(account.Likes))
+	for _, like := range account.Likes {
+		liked = liked.Insert(like.ID)
+	}
+	x.liked[stored.ID] = liked
+
+	blocked := make(IDSet, 0, len(account.Blocked))
+	for _, id := range account.Blocked {
+		blocked = blocked.Insert(id)
+	}
+	x.blocked[stored.ID] = blocked
+	return &stored
+}
+
+// RecommendPosting is the ids of the accounts of a bucket having the
+// interest, it is the form the buckets are stored in the snapshot
+type RecommendPosting struct {
+	Sex      string
+	Premium  bool
+	Status   string
+	Interest string
+	IDs      IDSet
+}
+
+// Postings passes all postings of the buckets to fn
+func (x *RecommendIndex) Postings(fn func(posting RecommendPosting) error) error {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	for key, postings := range x.buckets {
+		for interest, ids := range postings {
+			err := fn(RecommendPosting{Sex: key.sex, Premium: key.premium, Status: key.status, Interest: interest, IDs: ids})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// RestorePostings puts back the postings passed by Postings,
+// the postings built for another current time are rebuilt by SetNow
+func (x *RecommendIndex) RestorePostings(postings []RecommendPosting) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	for _, posting := range postings {
+		key := bucketKey{sex: posting.Sex, premium: posting.Premium, status: posting.Status}
+		bucket, ok := x.buckets[key]
+		if !ok {
+			bucket = make(map[string]IDSet)
+			x.buckets[key] = bucket
+		}
+		bucket[posting.Interest] = posting.IDs
+	}
+}
+
+// AddLiked records the likes set by the account
+func (x *RecommendIndex) AddLiked(id int, likes []Like) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	liked := x.liked[id]
+	for _, like := range likes {
+		liked = liked.Insert(like.ID)
+	}
+	x.liked[id] = liked
+}
+
+// Get returns the indexed fields of the account
+func (x *RecommendIndex) Get(id int) (Account, bool) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	account, ok := x.accounts[id]
+	if !ok {
+		return Account{}, false
+	}
+	return *account, true
+}
+
+// IDs returns the ids of all indexed accounts
+func (x *RecommendIndex) IDs() []int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	ids := make([]int, 0, len(x.accounts))
+	for id := range x.accounts {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Recommend returns up to limit accepted accounts of the opposite sex sharing
+// an interest with the account, best scored first. Blocked accounts and,
+// on request, the liked ones are never returned. The age range of the query
+// or the account preference bounds the candidate births. Buckets are walked from
+// the most to the least compatible one, the buckets where no account
+// can get into the result are skipped. A birth range smaller than the
+// postings is walked instead of the buckets.
+func (x *RecommendIndex) Recommend(id int, q RecommendQuery) ([]Account, error) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	stored, ok := x.accounts[id]
+	if !ok {
+		return nil, ErrAccountNotFound
+	}
+	account := *stored
+	account.PrepareInterestsMap()
+
+	sex := "f"
+	if account.Sex == "f" {
+		sex = "m"
+	}
+
+	blocked := x.blocked[id]
+	liked := x.liked[id]
+	birthAfter, birthUntil := q.Ages.Or(account.AgePreference()).Births(x.now)
+
+	top := NewTopK(q.Limit)
+	consider := func(candidateID int) {
+		if blocked.Contains(candidateID) || q.ExcludeLiked && liked.Contains(candidateID) {
+			return
+		}
+		candidate := *x.accounts[candidateID]
+		if candidate.Birth <= birthAfter || candidate.Birth > birthUntil {
+			return
+		}
+		if q.Accept != nil && !q.Accept(candidate) {
+			return
+		}
+		top.Push(Candidate{Account: candidate, Score: q.Scorer.Score(&account, &candidate, x.now)})
+	}
+
+	//the birth range is walked instead of the buckets when it holds fewer accounts
+	//than the postings, the candidates are the same and the ranking is total
+	if birthAfter != math.MinInt64 || birthUntil != math.MaxInt64 {
+		births := x.birthRange(sex, birthAfter, birthUntil)
+		if len(births) < x.postingsSize(sex, account.Interests) {
+			for _, entry := range births {
+				if account.sharesInterest(x.accounts[entry.id]) {
+					consider(entry.id)
+				}
+			}
+			return top.Accounts(), nil
+		}
+	}
+
+	for _, premium := range []bool{true, false} {
+		for _, status := range bucketStatuses {
+			if top.Full() {
+				worst, ok := top.Worst()
+				if !ok || worst.Score > q.Scorer.Bound(&account, premium, status) {
+					continue
+				}
+			}
+
+			postings := x.buckets[bucketKey{sex: sex, premium: premium, status: status}]
+			seen := make(map[int]bool)
+			for _, interest := range account.Interests {
+				for _, candidateID := range postings[interest] {
+					if seen[candidateID] {
+						continue
+					}
+					seen[candidateID] = true
+					consider(candidateID)
+				}
+			}
+		}
+	}
+	return top.Accounts(), nil
+}
+
+// postingsSize returns the number of ids in the postings of the interests
+// over all buckets of the sex
+func (x *RecommendIndex) postingsSize(sex string, interests []string) int {
+	n := 0
+	for key, postings := range x.buckets {
+		if key.sex != sex {
+			continue
+		}
+		for _, interest := range interests {
+			n += len(postings[interest])
+		}
+	}
+	return n
+}
+
+// birthRange returns the accounts of the sex born in (after, until],
+// it is called under the read lock
+func (x *RecommendIndex) birthRange(sex string, after, until int) []birthEntry {
+	x.birthsMu.Lock()
+	if pending := x.pending[sex]; len(pending) > 0 {
+		sortBirths(pending)
+		x.births[sex] = mergeBirths(x.births[sex], pending)
+		delete(x.pending, sex)
+	}
+	births := x.births[sex]
+	x.birthsMu.Unlock()
+
+	start := sort.Search(len(births), func(i int) bool {
+		return births[i].birth > after
+	})
+	end := sort.Search(len(births), func(i int) bool {
+		return births[i].birth > until
+	})
+	return births[start:end]
+}
+
+// removeBirth drops the account from the birth range index, it is called under the write lock
+func (x *RecommendIndex) removeBirth(account *Account) {
+	entry := birthEntry{birth: account.Birth, id: account.ID}
+	births := x.births[account.Sex]
+	i := sort.Search(len(births), func(i int) bool {
+		return !birthBefore(births[i], entry)
+	})
+	if i < len(births) && births[i] == entry {
+		x.births[account.Sex] = append(births[:i], births[i+1:]...)
+		return
+	}
+
+	pending := x.pending[account.Sex]
+	for i := range pending {
+		if pending[i] == entry {
+			x.pending[account.Sex] = append(pending[:i], pending[i+1:]...)
+			return
+		}
+	}
+}
+
+func birthBefore(x, y birthEntry) bool {
+	if x.birth != y.birth {
+		return x.birth < y.birth
+	}
+	return x.id < y.id
+}
+
+func sortBirths(births []birthEntry) {
+	sort.Slice(births, func(i, j int) bool {
+		return birthBefore(births[i], births[j])
+	})
+}
+
+// mergeBirths merges two sorted lists into a new one, the slices
+// returned by birthRange earlier stay untouched
+func mergeBirths(births, added []birthEntry) []birthEntry {
+	merged := make([]birthEntry, 0, len(births)+len(added))
+	i, j := 0, 0
+	for i < len(births) && j < len(added) {
+		if birthBefore(added[j], births[i]) {
+			merged = append(merged, added[j])
+			j++
+		} else {
+			merged = append(merged, births[i])
+			i++
+		}
+	}
+	merged = append(merged, births[i:]...)
+	return append(merged, added[j:]...)
+}
+
+func (x *RecommendIndex) keyOf(account *Account) bucketKey {
+	status := account.Status
+	if _, ok := statusTiers[status]; !ok {
+		status = ""
+	}
+	return bucketKey{sex: account.Sex, premium: account.isPremium(x.now), status: status}
+}
+
+func (x *RecommendIndex) index(account *Account) {
+	key := x.keyOf(account)
+	postings, ok := x.buckets[key]
+	if !ok {
+		postings = make(map[string]IDSet)
+		x.buckets[key] = postings
+	}
+	for _, interest := range account.Interests {
+		postings[interest] = postings[interest].Insert(account.ID)
+	}
+}
+
+func (x *RecommendIndex) unindex(account *Account) {
+	postings := x.buckets[x.keyOf(account)]
+	for _, interest := range account.Interests {
+		postings[interest] = postings[interest].Remove(account.ID)
+		if len(postings[interest]) == 0 {
+			delete(postings, interest)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package models
+
+import (
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+const testNow = 1546300800 //2019-01-01
+
+func TestRecommendAgeRangeMatchesFullScan(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	interests := []string{"a", "b", "c", "d", "e", "f"}
+	x := NewRecommendIndex()
+	x.SetNow(testNow)
+	accounts := make([]Account, 400)
+	for i := range accounts {
+		account := Account{
+			ID:     i + 1,
+			Sex:    []string{"m", "f"}[random.Intn(2)],
+			Status: Statuses[random.Intn(len(Statuses))],
+			Birth:  testNow - (18+random.Intn(30))*int(SecondsPerYear) - random.Intn(int(SecondsPerYear)),
+		}
+		for _, interest := range interests {
+			if random.Intn(3) == 0 {
+				account.Interests = append(account.Interests, interest)
+			}
+		}
+		if random.Intn(4) == 0 {
+			account.Premium = &Premium{Start: testNow - 100, Finish: testNow + 100}
+		}
+		accounts[i] = account
+		x.Put(account)
+	}
+
+	//a birth change after the index is merged moves the account in the range
+	accounts[0].Birth = testNow - 30*int(SecondsPerYear)
+	x.Recommend(accounts[0].ID, RecommendQuery{Limit: 1, Scorer: Scorers[DefaultScorer], Ages: AgeRange{Min: 20, Max: 21}})
+	accounts[0].Birth = testNow - 25*int(SecondsPerYear)
+	x.Put(accounts[0])
+
+	ranges := []AgeRange{{}, {Min: 25}, {Max: 30}, {Min: 25, Max: 26}, {Min: 40, Max: 40}}
+	for _, ages := range ranges {
+		for _, account := range accounts[:50] {
+			q := RecommendQuery{Limit: 5, Scorer: Scorers[DefaultScorer], Ages: ages}
+			got, err := x.Recommend(account.ID, q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := fullScan(accounts, account, q)
+			if !reflect.DeepEqual(ids(got), ids(want)) {
+				t.Errorf("recommend %d ages %+v = %v, want %v", account.ID, ages, ids(got), ids(want))
+			}
+		}
+	}
+}
+
+func TestRecommendExcludesLikedAndBlocked(t *testing.T) {
+	random := rand.New(rand.NewSource(2))
+	x := NewRecommendIndex()
+	x.SetNow(testNow)
+	accounts := make([]Account, 200)
+	for i := range accounts {
+		account := Account{
+			ID:        i + 1,
+			Sex:       []string{"m", "f"}[random.Intn(2)],
+			Status:    Statuses[random.Intn(len(Statuses))],
+			Birth:     testNow - (18+random.Intn(30))*int(SecondsPerYear),
+			Interests: []string{"a"},
+		}
+		for j := random.Intn(30); j > 0; j-- {
+			account.Likes = append(account.Likes, Like{ID: random.Intn(len(accounts)) + 1})
+		}
+		for j := random.Intn(30); j > 0; j-- {
+			account.Blocked = append(account.Blocked, random.Intn(len(accounts))+1)
+		}
+		accounts[i] = account
+		x.Put(account)
+	}
+	//the likes added after the account is indexed are excluded as well
+	for i := range accounts[:50] {
+		likes := []Like{{ID: random.Intn(len(accounts)) + 1}}
+		accounts[i].Likes = append(accounts[i].Likes, likes...)
+		x.AddLiked(accounts[i].ID, likes)
+	}
+
+	for _, excludeLiked := range []bool{false, true} {
+		for _, account := range accounts[:50] {
+			q := RecommendQuery{Limit: 20, Scorer: Scorers[DefaultScorer], ExcludeLiked: excludeLiked}
+			got, err := x.Recommend(account.ID, q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := fullScan(accounts, account, q); !reflect.DeepEqual(ids(got), ids(want)) {
+				t.Errorf("recommend %d exclude_liked=%v = %v, want %v", account.ID, excludeLiked, ids(got), ids(want))
+			}
+		}
+	}
+}
+
+// fullScan ranks every account the way Recommend does, without the indexes
+func fullScan(accounts []Account, account Account, q RecommendQuery) []Account {
+	account.PrepareInterestsMap()
+	excluded := make(map[int]bool)
+	for _, id := range account.Blocked {
+		excluded[id] = true
+	}
+	if q.ExcludeLiked {
+		for _, like := range account.Likes {
+			excluded[like.ID] = true
+		}
+	}
+	candidates := make([]Candidate, 0)
+	for i := range accounts {
+		candidate := accounts[i]
+		if candidate.Sex == account.Sex || !account.sharesInterest(&candidate) || excluded[candidate.ID] ||
+			!q.Ages.Or(account.AgePreference()).Contains(candidate.Birth, testNow) {
+			continue
+		}
+		candidates = append(candidates, Candidate{Account: candidate, Score: q.Scorer.Score(&account, &candidate, testNow)})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return rankedBefore(candidates[i], candidates[j])
+	})
+	if len(candidates) > q.Limit {
+		candidates = candidates[:q.Limit]
+	}
+	result := make([]Account, len(candidates))
+	for i := range candidates {
+		result[i] = candidates[i].Account
+	}
+	return result
+}
+
+func ids(accounts []Account) []int {
+	result := make([]int, len(accounts))
+	for i := range accounts {
+		result[i] = accounts[i].ID
+	}
+	return result
+}
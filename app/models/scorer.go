@@ -0,0 +1,125 @@
+package models
+
+// Scorer ranks the recommend candidates of an account, higher score is better.
+// The account passed to a Scorer has its interests map prepared.
+type Scorer interface {
+	Score(account, candidate *Account, now int) float64
+	// Explain returns the components the score is computed from
+	Explain(account, candidate *Account, now int) ScoreComponents
+	// Bound is the highest score a candidate with the given premium state and status can reach
+	Bound(account *Account, premium bool, status string) float64
+}
+
+// ScoreComponents are what a recommend score is computed from
+type ScoreComponents struct {
+	Premium         bool     //premium is active
+	StatusTier      int      //3 for "свободны" down to 0 for an unknown status
+	SharedInterests []string //interests of the candidate the account has too
+	AgeGap          int      //difference of the birth timestamps
+	Score           float64
+}
+
+const (
+	DefaultScorer = "compatibility"
+
+	SecondsPerYear = 365.25 * 24 * 60 * 60
+)
+
+// Scorers are the recommend strategies selectable by name
+var Scorers = map[string]Scorer{
+	DefaultScorer: CompatibilityScorer{},
+	"jaccard": JaccardScorer{
+		Interests: 1,
+		Premium:   0.5,
+		Status:    map[string]float64{"свободны": 0.3, "всё сложно": 0.2, "заняты": 0.1},
+		Age:       0.1,
+	},
+}
+
+// CompatibilityScorer is the original ranking: premium, then status,
+// then the number of shared interests, then the age gap
+type CompatibilityScorer struct{}
+
+func (CompatibilityScorer) Score(account, candidate *Account, now int) float64 {
+	return float64(account.CheckCompatibility(*candidate, now))
+}
+
+func (CompatibilityScorer) Explain(account, candidate *Account, now int) ScoreComponents {
+	return account.ExplainCompatibility(*candidate, now)
+}
+
+func (CompatibilityScorer) Bound(account *Account, premium bool, status string) float64 {
+	return float64(account.CompatibilityBound(premium, status))
+}
+
+// JaccardScorer blends the Jaccard index of the interests
+// with premium, status and age gap using the given weights
+type JaccardScorer struct {
+	Interests float64
+	Premium   float64
+	Status    map[string]float64
+	Age       float64 //divided by one plus the age gap in years
+}
+
+func (s JaccardScorer) Score(account, candidate *Account, now int) float64 {
+	return s.jaccard(account, candidate, now, nil)
+}
+
+func (s JaccardScorer) Explain(account, candidate *Account, now int) ScoreComponents {
+	components := ScoreComponents{SharedInterests: make([]string, 0)}
+	components.Score = s.jaccard(account, candidate, now, &components)
+	return components
+}
+
+// jaccard computes the score and fills the components when they are requested
+func (s JaccardScorer) jaccard(account, candidate *Account, now int, components *ScoreComponents) float64 {
+	shared := 0
+	union := len(account.interestsMap)
+	counted := make(map[string]bool, len(candidate.Interests))
+	for _, interest := range candidate.Interests {
+		if counted[interest] {
+			continue
+		}
+		counted[interest] = true
+		if account.interestsMap[interest] {
+			shared++
+			if components != nil {
+				components.SharedInterests = append(components.SharedInterests, interest)
+			}
+		} else {
+			union++
+		}
+	}
+
+	var score float64
+	if union > 0 {
+		score = s.Interests * float64(shared) / float64(union)
+	}
+	score += s.Status[candidate.Status]
+
+	premium := candidate.isPremium(now)
+	if premium {
+		score += s.Premium
+	}
+
+	ageGap := account.Birth - candidate.Birth
+	if ageGap < 0 {
+		ageGap = -ageGap
+	}
+	score += s.Age / (1 + float64(ageGap)/SecondsPerYear)
+
+	if components != nil {
+		components.Premium = premium
+		components.StatusTier = statusTiers[candidate.Status]
+		components.AgeGap = ageGap
+	}
+	return score
+}
+
+func (s JaccardScorer) Bound(account *Account, premium bool, status string) float64 {
+	bound := s.Interests + s.Status[status] + s.Age
+	if premium {
+		bound += s.Premium
+	}
+	return bound
+}
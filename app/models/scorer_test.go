@@ -0,0 +1,100 @@
+package models
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func randomScoredAccount(random *rand.Rand, id int) Account {
+	account := Account{
+		ID:     id,
+		Status: Statuses[random.Intn(len(Statuses))],
+		Birth:  testNow - (18+random.Intn(40))*int(SecondsPerYear) - random.Intn(int(SecondsPerYear)),
+	}
+	for _, interest := range []string{"a", "b", "c", "d", "e"} {
+		if random.Intn(2) == 0 {
+			account.Interests = append(account.Interests, interest)
+		}
+	}
+	if random.Intn(2) == 0 {
+		start := testNow + (random.Intn(3)-1)*100
+		account.Premium = &Premium{Start: start, Finish: start + 50}
+	}
+	return account
+}
+
+// Every scorer explains the score it gives and never scores above its bound,
+// which the recommend index relies on to skip the buckets.
+func TestScorersBoundAndExplain(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	for name, scorer := range Scorers {
+		for i := 0; i < 500; i++ {
+			account := randomScoredAccount(random, 1)
+			candidate := randomScoredAccount(random, 2)
+			account.PrepareInterestsMap()
+
+			score := scorer.Score(&account, &candidate, testNow)
+			bound := scorer.Bound(&account, candidate.isPremium(testNow), candidate.Status)
+			if score > bound {
+				t.Fatalf("%s: score %v above the bound %v for %+v and %+v", name, score, bound, account, candidate)
+			}
+			explanation := scorer.Explain(&account, &candidate, testNow)
+			if explanation.Score != score {
+				t.Fatalf("%s: explained score %v, want %v", name, explanation.Score, score)
+			}
+			if explanation.Premium != candidate.isPremium(testNow) || explanation.StatusTier != statusTiers[candidate.Status] {
+				t.Fatalf("%s: explanation %+v of %+v", name, explanation, candidate)
+			}
+		}
+	}
+}
+
+// The compatibility ranks by premium, then status, then shared interests, then the age gap.
+func TestCompatibilityScorerOrder(t *testing.T) {
+	account := Account{Birth: 600000000, Interests: []string{"a", "b"}}
+	account.PrepareInterestsMap()
+	premium := &Premium{Start: testNow - 10, Finish: testNow + 10}
+
+	//every candidate ranks above the next one
+	candidates := []Account{
+		{Birth: 500000000, Status: "заняты", Premium: premium},
+		{Birth: 600000000, Status: "свободны", Interests: []string{"a", "b"}},
+		{Birth: 500000000, Status: "свободны", Interests: []string{"a", "b"}},
+		{Birth: 600000000, Status: "свободны", Interests: []string{"a"}},
+		{Birth: 600000000, Status: "всё сложно", Interests: []string{"a", "b"}},
+		{Birth: 600000000, Status: "заняты", Interests: []string{"a", "b"}},
+	}
+	scorer := Scorers[DefaultScorer]
+	for i := 1; i < len(candidates); i++ {
+		previous := scorer.Score(&account, &candidates[i-1], testNow)
+		score := scorer.Score(&account, &candidates[i], testNow)
+		if previous <= score {
+			t.Errorf("candidate %d scores %v, not above candidate %d with %v", i-1, previous, i, score)
+		}
+	}
+}
+
+func TestJaccardScorer(t *testing.T) {
+	scorer := JaccardScorer{Interests: 1, Premium: 0.5, Status: map[string]float64{"свободны": 0.3}, Age: 0.1}
+	account := Account{Birth: 600000000, Interests: []string{"a", "b", "c"}}
+	account.PrepareInterestsMap()
+	year := int(SecondsPerYear)
+
+	tests := []struct {
+		name      string
+		candidate Account
+		want      float64
+	}{
+		{"same interests", Account{Birth: 600000000, Interests: []string{"c", "b", "a"}}, 1 + 0.1},
+		{"half shared", Account{Birth: 600000000, Interests: []string{"a", "d", "a"}}, 0.25 + 0.1},
+		{"status and premium", Account{Birth: 600000000, Status: "свободны",
+			Premium: &Premium{Start: testNow - 1, Finish: testNow + 1}}, 0.3 + 0.5 + 0.1},
+		{"a year apart", Account{Birth: 600000000 + year, Interests: []string{"a"}}, 1.0/3 + 0.05},
+	}
+	for _, tt := range tests {
+		if got := scorer.Score(&account, &tt.candidate, testNow); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: score %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package models
+
+import "math"
+
+// Similarity is a suggest strategy comparing the like vectors of two accounts
+type Similarity interface {
+	Similarity(v, o LikeVector) float64
+}
+
+// TimestampSimilarity is the original strategy, see LikeVector.Similarity
+type TimestampSimilarity struct{}
+
+func (TimestampSimilarity) Similarity(v, o LikeVector) float64 {
+	return v.Similarity(o)
+}
+
+// DecaySimilarity weights every shared likee of TimestampSimilarity
+// by 0.5^(age/HalfLife), where age is the time passed since the average
+// of both like times. Likes made after Now are not decayed.
+type DecaySimilarity struct {
+	HalfLife float64 //seconds, must be positive
+	Now      int
+}
+
+func (s DecaySimilarity) Similarity(v, o LikeVector) float64 {
+	var similarity float64
+	v.join(o, func(x, y LikeEntry) {
+		age := float64(s.Now) - (x.AvgTS()+y.AvgTS())/2
+		if age < 0 {
+			age = 0
+		}
+		similarity += pairSimilarity(x, y) * math.Exp2(-age/s.HalfLife)
+	})
+	return similarity
+}
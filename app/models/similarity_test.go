@@ -0,0 +1,158 @@
+package models
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSimilarity(t *testing.T) {
+	const day = 24 * 60 * 60
+	tests := []struct {
+		name      string
+		v, o      []Like
+		halfLife  float64
+		now       int
+		timestamp float64
+		decay     float64
+	}{
+		{
+			name:      "no shared likes",
+			v:         []Like{{ID: 1, TS: 100}},
+			o:         []Like{{ID: 2, TS: 100}},
+			halfLife:  day,
+			now:       100,
+			timestamp: 0,
+			decay:     0,
+		},
+		{
+			name:      "single likes at equal timestamps",
+			v:         []Like{{ID: 1, TS: 100}},
+			o:         []Like{{ID: 1, TS: 100}},
+			halfLife:  day,
+			now:       100,
+			timestamp: 1,
+			decay:     1,
+		},
+		{
+			name:      "equal timestamps one half-life ago",
+			v:         []Like{{ID: 1, TS: 100}},
+			o:         []Like{{ID: 1, TS: 100}},
+			halfLife:  day,
+			now:       100 + day,
+			timestamp: 1,
+			decay:     0.5,
+		},
+		{
+			name:      "single likes two half-lives ago",
+			v:         []Like{{ID: 1, TS: 90}},
+			o:         []Like{{ID: 1, TS: 110}},
+			halfLife:  day,
+			now:       100 + 2*day,
+			timestamp: 0.05,
+			decay:     0.0125,
+		},
+		{
+			name:      "likes after now are not decayed",
+			v:         []Like{{ID: 1, TS: 90}},
+			o:         []Like{{ID: 1, TS: 110}},
+			halfLife:  day,
+			now:       0,
+			timestamp: 0.05,
+			decay:     0.05,
+		},
+		{
+			name:      "repeated likes are averaged",
+			v:         []Like{{ID: 1, TS: 90}, {ID: 1, TS: 110}},
+			o:         []Like{{ID: 1, TS: 100}},
+			halfLife:  day,
+			now:       100 + day,
+			timestamp: 1,
+			decay:     0.5,
+		},
+		{
+			name:      "shared likees are summed",
+			v:         []Like{{ID: 1, TS: 100}, {ID: 2, TS: 100}, {ID: 3, TS: 100}},
+			o:         []Like{{ID: 1, TS: 100}, {ID: 3, TS: 104}},
+			halfLife:  day,
+			now:       102,
+			timestamp: 1.25,
+			decay:     math.Exp2(-2.0/day) + 0.25,
+		},
+	}
+
+	for _, tt := range tests {
+		v, o := NewLikeVector(tt.v), NewLikeVector(tt.o)
+
+		timestamp := TimestampSimilarity{}.Similarity(v, o)
+		if !closeTo(timestamp, tt.timestamp) {
+			t.Errorf("%s: timestamp similarity = %v, want %v", tt.name, timestamp, tt.timestamp)
+		}
+		decay := DecaySimilarity{HalfLife: tt.halfLife, Now: tt.now}.Similarity(v, o)
+		if !closeTo(decay, tt.decay) {
+			t.Errorf("%s: decay similarity = %v, want %v", tt.name, decay, tt.decay)
+		}
+		if reverse := (DecaySimilarity{HalfLife: tt.halfLife, Now: tt.now}).Similarity(o, v); !closeTo(reverse, decay) {
+			t.Errorf("%s: decay similarity is not symmetric, %v != %v", tt.name, reverse, decay)
+		}
+	}
+}
+
+func closeTo(x, y float64) bool {
+	return math.Abs(x-y) <= 1e-12*math.Max(1, math.Abs(y))
+}
+
+// referenceSimilarity is Account.CheckSimilarity before the like vectors,
+// it averages the like timestamps of every likee through maps
+func referenceSimilarity(a, b Account) float64 {
+	likesMap := func(account Account) map[int][]int {
+		likes := make(map[int][]int)
+		for _, like := range account.Likes {
+			likes[like.ID] = append(likes[like.ID], like.TS)
+		}
+		return likes
+	}
+	average := func(timestamps []int) float64 {
+		var sum float64
+		for _, ts := range timestamps {
+			sum += float64(ts)
+		}
+		return sum / float64(len(timestamps))
+	}
+
+	var similarity float64
+	myLikes := likesMap(a)
+	for id, likes := range likesMap(b) {
+		if mine, ok := myLikes[id]; ok {
+			avgMine, avg := average(mine), average(likes)
+			if avgMine == avg {
+				similarity++
+				continue
+			}
+			similarity += 1 / math.Abs(avgMine-avg)
+		}
+	}
+	return similarity
+}
+
+func TestSimilarityMatchesReference(t *testing.T) {
+	accounts := readFixture(t)
+	//a repeated like is averaged with the first one
+	accounts[0].Likes = append(accounts[0].Likes, Like{ID: accounts[0].Likes[0].ID, TS: fixtureNow - 50})
+
+	shared := 0
+	for _, a := range accounts {
+		v := NewLikeVector(a.Likes)
+		for _, b := range accounts {
+			want := referenceSimilarity(a, b)
+			if got := v.Similarity(NewLikeVector(b.Likes)); !closeTo(got, want) {
+				t.Errorf("similarity of %d and %d = %v, want %v", a.ID, b.ID, got, want)
+			}
+			if a.ID != b.ID && want > 0 {
+				shared++
+			}
+		}
+	}
+	if shared == 0 {
+		t.Fatal("no accounts of the fixture share a likee")
+	}
+}
@@ -0,0 +1,48 @@
+package models
+
+import "sync"
+
+type suggestRow struct {
+	version   int     //like version of the account the row was computed for
+	similar   []int32 //ids of the most similar accounts, most similar first
+	truncated bool    //more similar accounts were cut off
+}
+
+// SuggestTable keeps the precomputed similar accounts of every account
+type SuggestTable struct {
+	mu   sync.RWMutex
+	rows map[int]suggestRow
+}
+
+func NewSuggestTable() *SuggestTable {
+	return &SuggestTable{rows: make(map[int]suggestRow)}
+}
+
+// Put stores the similar accounts computed at the like version of the account
+func (t *SuggestTable) Put(id, version int, similar []int, truncated bool) {
+	row := suggestRow{version: version, similar: make([]int32, len(similar)), truncated: truncated}
+	for i, similarID := range similar {
+		row.similar[i] = int32(similarID)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rows[id] = row
+}
+
+// Get returns the similar accounts if they were computed at the like version,
+// truncated is true when the accounts are only the head of the full ranking
+func (t *SuggestTable) Get(id, version int) (similar []int, truncated bool, ok bool) {
+	t.mu.RLock()
+	row, ok := t.rows[id]
+	t.mu.RUnlock()
+	if !ok || row.version != version {
+		return nil, false, false
+	}
+
+	similar = make([]int, len(row.similar))
+	for i, similarID := range row.similar {
+		similar[i] = int(similarID)
+	}
+	return similar, row.truncated, true
+}
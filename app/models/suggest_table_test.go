@@ -0,0 +1,35 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSuggestTable(t *testing.T) {
+	table := NewSuggestTable()
+	similar := []int{5, 3, 9}
+	table.Put(1, 2, similar, true)
+	similar[0] = 7
+
+	got, truncated, ok := table.Get(1, 2)
+	if !ok || !truncated || !reflect.DeepEqual(got, []int{5, 3, 9}) {
+		t.Errorf("get = %v %v %v, want [5 3 9] true true", got, truncated, ok)
+	}
+	got[0] = 8
+	if got, _, _ := table.Get(1, 2); got[0] != 5 {
+		t.Errorf("the row is changed through the result: %v", got)
+	}
+
+	//a row computed at another like version is stale
+	if _, _, ok := table.Get(1, 3); ok {
+		t.Error("stale row returned")
+	}
+	if _, _, ok := table.Get(2, 0); ok {
+		t.Error("missing row returned")
+	}
+
+	table.Put(1, 3, []int{}, false)
+	if got, truncated, ok := table.Get(1, 3); !ok || truncated || len(got) != 0 {
+		t.Errorf("get after put = %v %v %v, want [] false true", got, truncated, ok)
+	}
+}
@@ -0,0 +1,83 @@
+package models
+
+import "container/heap"
+
+// Candidate is an account scored once against the recommended account
+type Candidate struct {
+	Account Account
+	Score   float64
+}
+
+// rankedBefore is the total recommendation order:
+// higher score first, lower id first on equal score
+func rankedBefore(x, y Candidate) bool {
+	if x.Score != y.Score {
+		return x.Score > y.Score
+	}
+	return x.Account.ID < y.Account.ID
+}
+
+// TopK keeps the k best candidates pushed so far
+type TopK struct {
+	k     int
+	items candidateHeap
+}
+
+func NewTopK(k int) *TopK {
+	return &TopK{k: k, items: make(candidateHeap, 0, k)}
+}
+
+// Push adds the candidate if it ranks before the worst kept one
+func (t *TopK) Push(c Candidate) {
+	if len(t.items) < t.k {
+		heap.Push(&t.items, c)
+		return
+	}
+	if t.k > 0 && rankedBefore(c, t.items[0]) {
+		t.items[0] = c
+		heap.Fix(&t.items, 0)
+	}
+}
+
+// Full reports whether k candidates are kept, so that only better ones can get in
+func (t *TopK) Full() bool {
+	return len(t.items) >= t.k
+}
+
+// Worst returns the last kept candidate
+func (t *TopK) Worst() (Candidate, bool) {
+	if len(t.items) == 0 {
+		return Candidate{}, false
+	}
+	return t.items[0], true
+}
+
+// Accounts returns the kept accounts in ranking order
+func (t *TopK) Accounts() []Account {
+	items := make(candidateHeap, len(t.items))
+	copy(items, t.items)
+	accounts := make([]Account, len(items))
+	for i := len(items) - 1; i >= 0; i-- {
+		accounts[i] = heap.Pop(&items).(Candidate).Account
+	}
+	return accounts
+}
+
+// candidateHeap keeps the worst ranked candidate on top
+type candidateHeap []Candidate
+
+func (h candidateHeap) Len() int           { return len(h) }
+func (h candidateHeap) Less(i, j int) bool { return rankedBefore(h[j], h[i]) }
+func (h candidateHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *candidateHeap) Push(x interface{}) {
+	*h = append(*h, x.(Candidate))
+}
+
+func (h *candidateHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
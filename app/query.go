@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// query runs a filter or group request against the stored accounts and prints
+// the JSON response, e.g. hlc query group keys=city order=-1 limit=5
+func query(opts opts, args []string) {
+	flags := flag.NewFlagSet("query", flag.ExitOnError)
+	_ = flags.Parse(args)
+	if flags.NArg() == 0 || flags.Arg(0) != "filter" && flags.Arg(0) != "group" {
+		log.Fatal("[ERROR] usage: hlc query filter|group [key=value ...]")
+	}
+
+	params := url.Values{}
+	for _, arg := range flags.Args()[1:] {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 {
+			log.Fatal("[ERROR] parameter must be key=value: ", arg)
+		}
+		params.Add(kv[0], kv[1])
+	}
+
+	app := newApp(opts, false)
+	//group answers the aggregates from the group index
+	if flags.Arg(0) == "group" {
+		err := app.IndexCollection()
+		if err != nil {
+			log.Fatal("[ERROR] ", err)
+		}
+	}
+	target := "/accounts/" + flags.Arg(0) + "/?" + params.Encode()
+	response := httptest.NewRecorder()
+	app.Handler().ServeHTTP(response, httptest.NewRequest(http.MethodGet, target, nil))
+	if response.Code != http.StatusOK {
+		log.Fatal("[ERROR] ", response.Code, " ", http.StatusText(response.Code))
+	}
+
+	_, err := response.Body.WriteTo(os.Stdout)
+	if err != nil {
+		log.Fatal("[ERROR] ", err)
+	}
+}
@@ -2,13 +2,18 @@ package rest
 
 import (
 	"encoding/json"
+	"errors"
+	"hlc/app/geo"
 	"hlc/app/models"
+	"hlc/app/storage"
 	"io"
 	"log"
+	"math"
 	"net/http"
-	"sort"
+	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/globalsign/mgo"
@@ -21,14 +26,34 @@ const (
 	accountsCollectionName = "accounts"
 )
 
+var errNoGazetteer = errors.New("within_km needs the gazetteer, none is loaded")
+var errWithinKmCity = errors.New("within_km needs city_eq")
+var errEmptyParameter = errors.New("empty parameter")
+var errNotLimit = errors.New("limit must be a non negative number")
+
 type App struct {
 	router       *mux.Router
 	mongoSession *mgo.Session
-	now          int //current time from options.txt
+	now          int          //current time from options.txt
+	loadedIDs    map[int]bool //ids of the accounts inserted by LoadData
+
+	recommendIndex *models.RecommendIndex
+	likeIndex      *models.LikeIndex
+	groupIndex     *models.GroupIndex
+	suggestTable   *models.SuggestTable
+	scorer         string         //default recommend strategy
+	gazetteer      *geo.Gazetteer //city coordinates for within_km, optional
+	decayDays      float64        //default like half-life of suggest, 0 disables the decay
+
+	mutations sync.Mutex   //serializes the account and like mutations
+	wal       *storage.WAL //log of the mutations, optional
+	walSeq    uint64       //sequence of the last mutation applied
+
+	adminToken string //bearer token of the /admin routes, empty disables them
 }
 
 func (a *App) Initialize(mongoAddr string) {
-	a.router = mux.NewRouter()
+	a.initialize()
 
 	session, err := mgo.Dial(mongoAddr)
 	//session, err := mgo.DialWithInfo(&mgo.DialInfo{
@@ -40,12 +65,61 @@ func (a *App) Initialize(mongoAddr string) {
 	}
 	a.mongoSession = session
 	//a.mongoSession.SetPoolLimit(500000)
+}
 
+// initialize creates the indexes and the routes, the database is dialed by Initialize
+func (a *App) initialize() {
+	a.router = mux.NewRouter()
+	a.loadedIDs = make(map[int]bool)
+	a.likeIndex = models.NewLikeIndex()
+	a.groupIndex = models.NewGroupIndex()
+	a.suggestTable = models.NewSuggestTable()
+	a.recommendIndex = models.NewRecommendIndex()
+	a.scorer = models.DefaultScorer
 	a.initializeRoutes()
 }
 
 func (a *App) SetNow(now int) {
 	a.now = now
+	a.recommendIndex.SetNow(now)
+	a.groupIndex.SetNow(now)
+}
+
+// SetAdminToken enables the /admin routes for the requests with the token,
+// an empty token disables them
+func (a *App) SetAdminToken(token string) {
+	a.adminToken = token
+}
+
+func (a *App) SetGazetteer(gazetteer *geo.Gazetteer) {
+	a.gazetteer = gazetteer
+}
+
+// SetDecay sets the like half-life in days used by suggest when the request does not set one
+func (a *App) SetDecay(days float64) error {
+	err := checkDecay(days)
+	if err != nil {
+		return err
+	}
+	a.decayDays = days
+	return nil
+}
+
+// checkDecay accepts a finite non negative half-life, zero disables the decay
+func checkDecay(days float64) error {
+	if days < 0 || math.IsNaN(days) || math.IsInf(days, 0) {
+		return errors.New("decay must be a non negative number of days")
+	}
+	return nil
+}
+
+// SetScorer selects the recommend strategy used when the request does not set one
+func (a *App) SetScorer(name string) error {
+	if _, ok := models.Scorers[name]; !ok {
+		return errors.New("unknown recommend strategy " + name)
+	}
+	a.scorer = name
+	return nil
 }
 
 func (a *App) DropCollection() {
@@ -56,20 +130,79 @@ func (a *App) DropCollection() {
 	if err != nil {
 		log.Println("[ERROR] ", err)
 	}
+
+	//the hashes of the imported files are stale without the accounts
+	err = session.DB(dbName).C(importsCollectionName).DropCollection()
+	if err != nil {
+		log.Println("[ERROR] ", err)
+	}
+}
+
+// EnsureIDIndex creates the unique id index, the export sorts by id and
+// the mutations look the accounts up by id
+func (a *App) EnsureIDIndex() error {
+	session := a.mongoSession.Copy()
+	defer session.Close()
+	collection := session.DB(dbName).C(accountsCollectionName)
+
+	return collection.EnsureIndex(mgo.Index{
+		Key:    []string{"id"},
+		Unique: true,
+	})
 }
 
 func (a *App) LoadData(accounts []models.Account) {
+	err := a.insertAccounts(accounts)
+	if err != nil {
+		log.Println("[ERROR] ", err)
+	}
+	log.Println("[INFO] all accounts added")
+}
+
+// insertAccounts stores the accounts and indexes them with their likes. An account
+// failing to insert is left out and the first error is returned after the others.
+func (a *App) insertAccounts(accounts []models.Account) error {
 	session := a.mongoSession.Copy()
 	defer session.Close()
 	collection := session.DB(dbName).C(accountsCollectionName)
 
+	//likes_received of the already inserted likees is incremented in bulk,
+	//the accounts inserted later pick up their counter from the like index
+	//and from the likes of the batch, which are indexed at the end of it
+	bulk := collection.Bulk()
+	bulk.Unordered()
+	inserted := make([]models.Account, 0, len(accounts))
+	received := make(map[int]int)
+	var failed error
 	for i, account := range accounts {
+		account.LikesReceived = a.likeIndex.Received(account.ID) + received[account.ID]
 		err := collection.Insert(&account)
 		if err != nil {
 			log.Println("[ERROR] index=", i, err)
+			if failed == nil {
+				failed = err
+			}
+			continue
+		}
+		a.loadedIDs[account.ID] = true
+		a.recommendIndex.Put(account)
+		a.groupIndex.Put(account)
+		inserted = append(inserted, account)
+		for _, like := range account.Likes {
+			if a.loadedIDs[like.ID] {
+				bulk.Update(bson.M{"id": like.ID}, bson.M{"$inc": bson.M{"likes_received": 1}})
+				a.groupIndex.AddReceived(like.ID)
+			} else {
+				received[like.ID]++
+			}
 		}
 	}
-	log.Println("[INFO] all accounts added")
+	a.likeIndex.AddAccounts(inserted)
+	_, err := bulk.Run()
+	if failed != nil {
+		return failed
+	}
+	return err
 }
 
 func (a *App) CheckDB() {
@@ -234,6 +367,16 @@ func (a *App) CreateIndexes(background bool) {
 	}
 }
 
+// AccountIDs returns the ids of the indexed accounts
+func (a *App) AccountIDs() []int {
+	return a.recommendIndex.IDs()
+}
+
+// Handler returns the router of the API, the commands query it without listening
+func (a *App) Handler() http.Handler {
+	return a.router
+}
+
 func (a *App) Run(listenAddr string) {
 	log.Println("[INFO] start server on", listenAddr)
 	log.Fatal("[ERROR] ", http.ListenAndServe(listenAddr, a.router))
@@ -243,9 +386,16 @@ func (a *App) initializeRoutes() {
 	//a.router.HandleFunc("/ping/", a.ping).Methods(http.MethodGet)
 
 	a.router.HandleFunc("/accounts/filter/", a.filter).Methods(http.MethodGet)
+	a.router.HandleFunc("/accounts/new/", a.create).Methods(http.MethodPost)
+	a.router.HandleFunc("/accounts/likes/", a.like).Methods(http.MethodPost)
+	a.router.HandleFunc("/accounts/{id}/", a.update).Methods(http.MethodPost)
 	a.router.HandleFunc("/accounts/group/", a.group).Methods(http.MethodGet)
 	a.router.HandleFunc("/accounts/{id}/recommend/", a.recommend).Methods(http.MethodGet)
 	a.router.HandleFunc("/accounts/{id}/suggest/", a.suggest).Methods(http.MethodGet)
+	a.router.HandleFunc("/accounts/{id}/matches/", a.matches).Methods(http.MethodGet)
+	a.router.HandleFunc("/accounts/{id}/likers/", a.likers).Methods(http.MethodGet)
+	a.router.HandleFunc("/admin/export", a.export).Methods(http.MethodGet)
+	a.router.HandleFunc("/admin/export/", a.export).Methods(http.MethodGet)
 }
 
 func (a *App) ping(w http.ResponseWriter, r *http.Request) {
@@ -256,146 +406,63 @@ func (a *App) ping(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (a *App) filter(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
+// filterQuery parses the /accounts/filter/ request into the mongo query and the limit
+func (a *App) filterQuery(values url.Values) (bson.M, int, error) {
 	query := bson.M{}
 	var limit int
-	for k, v := range r.URL.Query() {
+	var withinKm float64
+	for k, v := range values {
 		if v[0] == "" {
-			w.WriteHeader(http.StatusBadRequest)
-			return
+			return nil, 0, errEmptyParameter
 		}
 		switch k {
-		case "sex_eq":
-			query["sex"] = v[0]
-			continue
-		case "email_domain":
-			query["email"] = bson.M{"$regex": "(@" + v[0] + ")"}
-			continue
-		case "email_lt":
-			query["email"] = bson.M{"$lt": v[0]}
-			continue
-		case "email_gt":
-			query["email"] = bson.M{"$gt": v[0]}
-			continue
-		case "status_eq":
-			query["status"] = v[0]
-			continue
-		case "status_neq":
-			query["status"] = bson.M{"$ne": v[0]}
-			continue
-		case "fname_eq":
-			query["fname"] = v[0]
-			continue
-		case "fname_any":
-			query["fname"] = bson.M{"$in": strings.Split(v[0], ",")}
-			continue
-		case "fname_null":
-			query["fname"] = exists(v[0])
-			continue
-		case "sname_eq":
-			query["sname"] = v[0]
-			continue
-		case "sname_starts":
-			query["sname"] = bson.M{"$regex": "^" + v[0]}
-			continue
-		case "sname_null":
-			query["sname"] = exists(v[0])
-			continue
-		case "phone_code":
-			query["phone"] = bson.M{"$regex": "(\\(" + v[0] + "\\))"}
-			continue
-		case "phone_null":
-			query["phone"] = exists(v[0])
-			continue
-		case "country_eq":
-			query["country"] = v[0]
-			continue
-		case "country_null":
-			query["country"] = exists(v[0])
-			continue
-		case "city_eq":
-			query["city"] = v[0]
-			continue
-		case "city_any":
-			query["city"] = bson.M{"$in": strings.Split(v[0], ",")}
-			continue
-		case "city_null":
-			query["city"] = exists(v[0])
-			continue
-		case "birth_lt":
-			birth, err := strconv.Atoi(v[0])
-			if err != nil {
-				log.Println("[ERROR] ", err)
-				w.WriteHeader(http.StatusBadRequest)
-				return
-			}
-			query["birth"] = bson.M{"$lt": birth}
-			continue
-		case "birth_gt":
-			birth, err := strconv.Atoi(v[0])
-			if err != nil {
-				log.Println("[ERROR] ", err)
-				w.WriteHeader(http.StatusBadRequest)
-				return
-			}
-			query["birth"] = bson.M{"$gt": birth}
-			continue
-		case "birth_year":
-			year, err := strconv.Atoi(v[0])
-			if err != nil {
-				log.Println("[ERROR] ", err)
-				w.WriteHeader(http.StatusBadRequest)
-				return
-			}
-			query["birth"] = yearInterval(year)
-			continue
-		case "interests_contains":
-			query["interests"] = bson.M{"$all": strings.Split(v[0], ",")}
-			continue
-		case "interests_any":
-			query["interests"] = bson.M{"$elemMatch": bson.M{"$in": strings.Split(v[0], ",")}}
-			continue
-		case "likes_contains":
-			likes := strings.Split(v[0], ",")
-			likeIds := make([]int, 0)
-			for _, like := range likes {
-				l, err := strconv.Atoi(like)
-				if err != nil {
-					log.Println("[ERROR] ", err)
-					w.WriteHeader(http.StatusBadRequest)
-					return
-				}
-				likeIds = append(likeIds, l)
-			}
-			//log.Println("[DEBUG] ", likeIds)
-			query["likes"] = bson.M{"$elemMatch": bson.M{"id": bson.M{"$all": likeIds}}}
-			continue
-		case "premium_now":
-			query["$and"] = []bson.M{{"premium.start": bson.M{"$lt": a.now}}, {"premium.finish": bson.M{"$gt": a.now}}}
-			continue
-		case "premium_null":
-			query["premium"] = exists(v[0])
-			continue
 		case "limit":
 			var err error
 			limit, err = strconv.Atoi(v[0])
 			if err != nil {
 				log.Println("[ERROR] ", err)
-				w.WriteHeader(http.StatusBadRequest)
-				return
+				return nil, 0, err
 			}
 			if limit < 0 {
-				w.WriteHeader(http.StatusBadRequest)
-				return
+				return nil, 0, errNotLimit
+			}
+		case "within_km":
+			var err error
+			withinKm, err = parseKm(v[0])
+			if err != nil {
+				log.Println("[ERROR] ", err)
+				return nil, 0, err
 			}
-			continue
 		case "query_id":
-			continue
 		default:
-			w.WriteHeader(http.StatusBadRequest)
-			return
+			err := a.parsePredicate(query, k, v[0])
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+
+	//within_km widens city_eq to the cities around it
+	if withinKm > 0 {
+		if a.gazetteer == nil {
+			log.Println("[ERROR] ", errNoGazetteer)
+			return nil, 0, errNoGazetteer
 		}
+		city, ok := query["city"].(string)
+		if !ok {
+			return nil, 0, errWithinKmCity
+		}
+		query["city"] = bson.M{"$in": a.citiesWithin(city, withinKm)}
+	}
+	return query, limit, nil
+}
+
+func (a *App) filter(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	query, limit, err := a.filterQuery(r.URL.Query())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
 	}
 
 	//log.Println("[DEBUG] query=", query)
@@ -411,6 +478,7 @@ func (a *App) filter(w http.ResponseWriter, r *http.Request) {
 	for k, _ := range query {
 		if k != "interests" && k != "likes" {
 			if k == "$and" {
+				//the conditions in $and are on the premium or on the fields already selected
 				selector["premium"] = 1
 			} else {
 				selector[k] = 1
@@ -423,7 +491,7 @@ func (a *App) filter(w http.ResponseWriter, r *http.Request) {
 	filterResponse := models.Accounts{}
 	filterResponse.Accounts = make([]models.Account, 0)
 
-	err := collection.Find(query).Limit(limit).Sort("-id").Select(selector).All(&filterResponse.Accounts)
+	err = collection.Find(query).Limit(limit).Sort("-id").Select(selector).All(&filterResponse.Accounts)
 
 	if err != nil {
 		log.Println("[ERROR] ", err, query)
@@ -437,162 +505,241 @@ func (a *App) filter(w http.ResponseWriter, r *http.Request) {
 
 }
 
-func (a *App) group(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	query := bson.M{}
-	var limit, order int
-	keys := make([]string, 0)
-	for k, v := range r.URL.Query() {
+// groupRequest is a parsed /accounts/group/ request
+type groupRequest struct {
+	models.GroupQuery
+	query   bson.M //the filters for the database
+	indexed bool   //the filters are all answered by the group index
+	likee   int    //the groups are of the likers of the account, 0 is every account
+}
+
+// fromIndex reports whether the group index answers the request. The aggregates
+// come from its running sums, the likes filter from the likers in the like index.
+// The predicates, which the rows do not keep, and the interest filter grouped by
+// the interests key are left to the $group of the database.
+func (r groupRequest) fromIndex() bool {
+	return len(r.Aggregates) > 0 && r.indexed &&
+		!(r.Filter.Interest != "" && contains(r.Keys, "interests"))
+}
+
+var errBadOrder = errors.New("order must be -1 or 1")
+var errBadKey = errors.New("unknown group key")
+var errBadAggregate = errors.New("unknown aggregate")
+var errBadSort = errors.New("sort_by must be count or one of the aggregates")
+var errNoOrder = errors.New("order and limit are required")
+
+// groupQuery parses the /accounts/group/ request
+func (a *App) groupQuery(values url.Values) (groupRequest, error) {
+	request := groupRequest{query: bson.M{}, indexed: true}
+	request.Keys = make([]string, 0)
+	request.Aggregates = make([]string, 0)
+	request.SortBy = "count"
+	query := request.query
+	filter := &request.Filter
+	for k, v := range values {
 		if v[0] == "" {
-			w.WriteHeader(http.StatusBadRequest)
-			return
+			return request, errEmptyParameter
 		}
 		switch k {
 		case "sex":
-			query["sex"] = v[0]
-			continue
+			setCondition(query, "sex", v[0])
+			filter.Sex = v[0]
 		case "birth":
 			year, err := strconv.Atoi(v[0])
 			if err != nil {
 				log.Println("[ERROR] ", err)
-				w.WriteHeader(http.StatusBadRequest)
-				return
+				return request, err
 			}
-			query["birth"] = yearInterval(year)
-			continue
+			setCondition(query, "birth", yearInterval(year))
+			filter.BirthYear = year
 		case "country":
-			query["country"] = v[0]
-			continue
+			setCondition(query, "country", v[0])
+			filter.Country = v[0]
 		case "city":
-			query["city"] = v[0]
-			continue
+			setCondition(query, "city", v[0])
+			filter.City = v[0]
 		case "joined":
 			year, err := strconv.Atoi(v[0])
 			if err != nil {
 				log.Println("[ERROR] ", err)
-				w.WriteHeader(http.StatusBadRequest)
-				return
+				return request, err
 			}
-			query["joined"] = yearInterval(year)
-			continue
+			setCondition(query, "joined", yearInterval(year))
+			filter.JoinedYear = year
 		case "status":
-			query["status"] = v[0]
-			continue
+			setCondition(query, "status", v[0])
+			filter.Status = v[0]
 		case "interests":
-			query["interests"] = bson.M{"$elemMatch": bson.M{"$eq": v[0]}}
-			continue
+			setCondition(query, "interests", bson.M{"$elemMatch": bson.M{"$eq": v[0]}})
+			filter.Interest = v[0]
 		case "likes":
 			likeId, err := strconv.Atoi(v[0])
 			if err != nil {
 				log.Println("[ERROR] ", err)
-				w.WriteHeader(http.StatusBadRequest)
-				return
+				return request, err
 			}
-			query["likes"] = bson.M{"$elemMatch": bson.M{"id": likeId}}
-			continue
+			setCondition(query, "likes", bson.M{"$elemMatch": bson.M{"id": likeId}})
+			request.likee = likeId
 		case "limit":
 			var err error
-			limit, err = strconv.Atoi(v[0])
+			request.Limit, err = strconv.Atoi(v[0])
 			if err != nil {
 				log.Println("[ERROR] ", err)
-				w.WriteHeader(http.StatusBadRequest)
-				return
+				return request, err
 			}
-			if limit < 0 {
-				w.WriteHeader(http.StatusBadRequest)
-				return
+			if request.Limit < 0 {
+				return request, errNotLimit
 			}
-			continue
 		case "order":
 			var err error
-			order, err = strconv.Atoi(v[0])
+			request.Order, err = strconv.Atoi(v[0])
 			if err != nil {
-				w.WriteHeader(http.StatusBadRequest)
-				return
-			}
-
-			if order == 0 {
-				w.WriteHeader(http.StatusBadRequest)
-				return
+				return request, err
 			}
-
-			if order != -1 && order != 1 {
-				w.WriteHeader(http.StatusBadRequest)
-				return
+			if request.Order != -1 && request.Order != 1 {
+				return request, errBadOrder
 			}
-			continue
 		case "keys":
-			keys = strings.Split(v[0], ",")
-			if len(keys) == 0 {
-				w.WriteHeader(http.StatusBadRequest)
-				return
-			}
-			//validate keys
-			for _, key := range keys {
+			request.Keys = strings.Split(v[0], ",")
+			for _, key := range request.Keys {
 				if _, ok := models.Keys[key]; !ok {
-					w.WriteHeader(http.StatusBadRequest)
-					return
+					return request, errBadKey
 				}
 			}
-			continue
+		case "aggregates":
+			request.Aggregates = strings.Split(v[0], ",")
+			for _, aggregate := range request.Aggregates {
+				if _, ok := models.Aggregates[aggregate]; !ok {
+					return request, errBadAggregate
+				}
+			}
+		case "sort_by":
+			request.SortBy = v[0]
 		case "query_id":
-			continue
 		default:
-			w.WriteHeader(http.StatusBadRequest)
-			return
+			err := a.parsePredicate(query, k, v[0])
+			if err != nil {
+				return request, err
+			}
+			request.indexed = false
 		}
 	}
 
-	session := a.mongoSession.Copy()
-	defer session.Close()
-	collection := session.DB(dbName).C(accountsCollectionName)
+	//sorting is allowed by count or by one of the requested aggregates
+	if request.SortBy != "count" && !contains(request.Aggregates, request.SortBy) {
+		return request, errBadSort
+	}
+	if request.Order == 0 || request.Limit == 0 {
+		return request, errNoOrder
+	}
+	return request, nil
+}
+
+func (a *App) group(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	request, err := a.groupQuery(r.URL.Query())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
 
 	groups := models.Groups{}
 	groups.Groups = make([]models.Group, 0)
 
+	if request.fromIndex() {
+		index := a.groupIndex
+		if request.likee != 0 {
+			likers := a.likeIndex.Likers(request.likee, 0, 0, a.likeIndex.Received(request.likee))
+			ids := make([]int, len(likers))
+			for i, like := range likers {
+				ids[i] = like.ID
+			}
+			index = index.Subset(ids)
+		}
+		groups.Groups = index.Groups(request.GroupQuery)
+	} else {
+		session := a.mongoSession.Copy()
+		defer session.Close()
+		collection := session.DB(dbName).C(accountsCollectionName)
+
+		err = collection.Pipe(a.groupPipeline(request)).All(&groups.Groups)
+		if err != nil {
+			log.Println("[ERROR] ", err)
+		}
+	}
+
+	err = json.NewEncoder(w).Encode(groups)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Println("[ERROR] ", err)
+	}
+}
+
+// groupPipeline is the aggregation of the database which answers the request
+func (a *App) groupPipeline(request groupRequest) []bson.M {
+	keys, order := request.Keys, request.Order
 	groupPipe := bson.M{}
 	projectPipe := bson.M{"_id": 0, "count": 1}
-	sortPipe := bson.M{"count": order}
+	//groups are ordered by the sort field and then by the keys
+	//in the order they were requested, which makes the ordering total
+	sortPipe := bson.D{{Name: request.SortBy, Value: order}}
 	unwind := false
 	for _, key := range keys {
-		groupPipe[key] = "$" + key
+		if _, ok := groupPipe[key]; ok {
+			continue
+		}
+		groupPipe[key] = a.groupExpression(key)
 		projectPipe[key] = "$_id." + key
-		sortPipe[key] = order
+		sortKey := key
+		if key == "premium" {
+			projectPipe[key] = bson.M{"$arrayElemAt": []interface{}{models.PremiumStates, "$_id.premium"}}
+			sortKey = "premium_rank"
+			projectPipe[sortKey] = "$_id.premium"
+		}
+		sortPipe = append(sortPipe, bson.DocElem{Name: sortKey, Value: order})
 		if key == "interests" {
 			unwind = true
 		}
 	}
 
-	var pipeline []bson.M
-	if unwind {
-		pipeline = []bson.M{
-			{"$match": query},
-			{"$unwind": "$interests"},
-			{"$group": bson.M{"_id": groupPipe, "count": bson.M{"$sum": 1}}},
-			{"$project": projectPipe},
-			{"$sort": sortPipe},
-			{"$limit": limit},
-		}
-	} else {
-		pipeline = []bson.M{
-			{"$match": query},
-			{"$group": bson.M{"_id": groupPipe, "count": bson.M{"$sum": 1}}},
-			{"$project": projectPipe},
-			{"$sort": sortPipe},
-			{"$limit": limit},
-		}
+	//aggregates are accumulated as sums in the same pass as count
+	//and turned into averages in the projection
+	accumulatorPipe := bson.M{"_id": groupPipe, "count": bson.M{"$sum": 1}}
+	for _, aggregate := range request.Aggregates {
+		sum, accumulator, value := a.aggregateExpressions(aggregate)
+		accumulatorPipe[sum] = accumulator
+		projectPipe[aggregate] = value
 	}
 
-	err := collection.Pipe(pipeline).All(&groups.Groups)
-	if err != nil {
-		log.Println("[ERROR] ", err)
+	pipeline := []bson.M{{"$match": request.query}}
+	if unwind {
+		pipeline = append(pipeline, bson.M{"$unwind": "$interests"})
 	}
+	return append(pipeline,
+		bson.M{"$group": accumulatorPipe},
+		bson.M{"$project": projectPipe},
+		bson.M{"$sort": sortPipe},
+		bson.M{"$limit": request.Limit},
+	)
+}
 
-	err = json.NewEncoder(w).Encode(groups)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		log.Println("[ERROR] ", err)
-	}
+// explanation holds the components a recommend score is computed from
+type explanation struct {
+	Premium         bool     `json:"premium"`          //premium is active
+	StatusTier      int      `json:"status_tier"`      //3 for "свободны" down to 0 for an unknown status
+	SharedInterests []string `json:"shared_interests"` //interests of the candidate the account has too
+	AgeGap          int      `json:"age_gap"`          //difference of the birth timestamps
+	Score           float64  `json:"score"`
+}
+
+// recommendedAccount is a recommend result, the explanation is set in the explain mode
+type recommendedAccount struct {
+	models.Account
+	Explanation *explanation `json:"explanation,omitempty"`
+}
+
+type recommendedAccounts struct {
+	Accounts []recommendedAccount `json:"accounts"`
 }
 
 func (a *App) recommend(w http.ResponseWriter, r *http.Request) {
@@ -604,31 +751,18 @@ func (a *App) recommend(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	session := a.mongoSession.Copy()
-	defer session.Close()
-	collection := session.DB(dbName).C(accountsCollectionName)
-
-	account := models.Account{}
-	err = collection.Find(bson.M{"id": id}).Select(bson.M{
-		"sex":       1,
-		"birth":     1,
-		"interests": 1}).One(&account)
-	if err != nil {
-		log.Println("[ERROR] ", err)
-		if err == mgo.ErrNotFound {
-			w.WriteHeader(http.StatusNotFound)
-			return
-		}
-	}
-
-	query := bson.M{"sex": "f"}
-	if account.Sex == "f" {
-		query["sex"] = "m"
+	account, ok := a.recommendIndex.Get(id)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
 	}
 
-	query["interests"] = bson.M{"$elemMatch": bson.M{"$in": account.Interests}}
-
 	var limit int
+	var country, city string
+	var explain, excludeLiked bool
+	var withinKm float64
+	var ages models.AgeRange
+	scorer := models.Scorers[a.scorer]
 	for k, v := range r.URL.Query() {
 		if v[0] == "" {
 			w.WriteHeader(http.StatusBadRequest)
@@ -636,59 +770,115 @@ func (a *App) recommend(w http.ResponseWriter, r *http.Request) {
 		}
 		switch k {
 		case "country":
-			query["country"] = v[0]
+			country = v[0]
 			continue
 		case "city":
-			query["city"] = v[0]
+			city = v[0]
 			continue
-		case "limit":
+		case "within_km":
 			var err error
-			limit, err = strconv.Atoi(v[0])
+			withinKm, err = parseKm(v[0])
 			if err != nil {
 				log.Println("[ERROR] ", err)
 				w.WriteHeader(http.StatusBadRequest)
 				return
 			}
-			if limit < 0 {
+			if a.gazetteer == nil {
+				log.Println("[ERROR] ", errNoGazetteer)
 				w.WriteHeader(http.StatusBadRequest)
 				return
 			}
 			continue
-		case "query_id":
+		case "age_min":
+			ages.Min, err = parseAge(v[0])
+			if err != nil {
+				log.Println("[ERROR] ", err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
 			continue
-		default:
-			w.WriteHeader(http.StatusBadRequest)
-			return
-		}
-	}
-
-	accounts := models.Accounts{}
-	accounts.Accounts = make([]models.Account, 0)
-
-	err = collection.Find(query).Select(bson.M{
-		"id":        1,
-		"email":     1,
-		"status":    1,
-		"fname":     1,
-		"sname":     1,
-		"birth":     1,
-		"premium":   1,
-		"interests": 1}).All(&accounts.Accounts)
-	if err != nil {
-		log.Println("[ERROR] ", err)
+		case "age_max":
+			ages.Max, err = parseAge(v[0])
+			if err != nil {
+				log.Println("[ERROR] ", err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			continue
+		case "exclude_liked":
+			excludeLiked = v[0] == "1"
+			continue
+		case "explain":
+			explain = v[0] == "1"
+			continue
+		case "strategy":
+			var ok bool
+			scorer, ok = models.Scorers[v[0]]
+			if !ok {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			continue
+		case "limit":
+			var err error
+			limit, err = strconv.Atoi(v[0])
+			if err != nil {
+				log.Println("[ERROR] ", err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if limit < 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			continue
+		case "query_id":
+			continue
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	//within_km keeps the candidates from the cities around the account one
+	var nearby map[string]bool
+	if withinKm > 0 {
+		nearby = make(map[string]bool)
+		for _, name := range a.citiesWithin(account.City, withinKm) {
+			nearby[name] = true
+		}
 	}
 
-	account.PrepareInterestsMap()
-	sort.Slice(accounts.Accounts, func(i, j int) bool {
-		return account.CheckCompatibility(accounts.Accounts[i], a.now) > account.CheckCompatibility(accounts.Accounts[j], a.now)
+	recommended, err := a.recommendIndex.Recommend(id, models.RecommendQuery{
+		Limit:        limit,
+		Scorer:       scorer,
+		ExcludeLiked: excludeLiked,
+		Ages:         ages,
+		Accept: func(candidate models.Account) bool {
+			return (country == "" || candidate.Country == country) &&
+				(city == "" || candidate.City == city) &&
+				(nearby == nil || nearby[candidate.City])
+		},
 	})
+	if err != nil {
+		log.Println("[ERROR] ", err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
 
-	if len(accounts.Accounts) > limit {
-		accounts.Accounts = accounts.Accounts[:limit]
+	accounts := recommendedAccounts{}
+	accounts.Accounts = make([]recommendedAccount, len(recommended))
+	if explain {
+		account.PrepareInterestsMap()
 	}
+	for i := range recommended {
+		//the explanation is computed by the scorer which ranked the results
+		if explain {
+			explained := explanation(scorer.Explain(&account, &recommended[i], a.now))
+			accounts.Accounts[i].Explanation = &explained
+		}
 
-	for i, _ := range accounts.Accounts {
-		accounts.Accounts[i].Interests = []string{}
+		accounts.Accounts[i].Account = recommendProfile(recommended[i])
 	}
 
 	err = json.NewEncoder(w).Encode(accounts)
@@ -707,25 +897,203 @@ func (a *App) suggest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	session := a.mongoSession.Copy()
-	defer session.Close()
-	collection := session.DB(dbName).C(accountsCollectionName)
+	account, ok := a.recommendIndex.Get(id)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
 
-	account := models.Account{}
-	err = collection.Find(bson.M{"id": id}).Select(bson.M{
-		"sex":   1,
-		"likes": 1}).One(&account)
+	var limit int
+	var country, city string
+	var ages models.AgeRange
+	decayDays := a.decayDays
+	for k, v := range r.URL.Query() {
+		if v[0] == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch k {
+		case "country":
+			country = v[0]
+			continue
+		case "city":
+			city = v[0]
+			continue
+		case "limit":
+			var err error
+			limit, err = strconv.Atoi(v[0])
+			if err != nil {
+				log.Println("[ERROR] ", err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if limit < 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			continue
+		case "decay":
+			decayDays, err = strconv.ParseFloat(v[0], 64)
+			if err == nil {
+				err = checkDecay(decayDays)
+			}
+			if err != nil {
+				log.Println("[ERROR] ", err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			continue
+		case "age_min":
+			ages.Min, err = parseAge(v[0])
+			if err != nil {
+				log.Println("[ERROR] ", err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			continue
+		case "age_max":
+			ages.Max, err = parseAge(v[0])
+			if err != nil {
+				log.Println("[ERROR] ", err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			continue
+		case "query_id":
+			continue
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	ages = ages.Or(account.AgePreference())
+	vector := a.likeIndex.Vector(id)
+
+	//the age range bounds the suggested accounts, not the similar ones
+	suggest := func(suggested models.Account) bool {
+		return ages.Contains(suggested.Birth, a.now)
+	}
+
+	//the precomputed similar accounts are used for the unfiltered requests
+	//unless the account likes changed since they were computed
+	var ids []int
+	plain := country == "" && city == "" && decayDays == 0
+	if similar, truncated, ok := a.suggestTable.Get(id, a.likeIndex.Version(id)); plain && ok {
+		ids = a.collectSuggestions(id, vector, similar, limit, suggest)
+		if len(ids) < limit && truncated {
+			ids = nil
+		}
+	}
+
+	if ids == nil {
+		var similarity models.Similarity = models.TimestampSimilarity{}
+		if decayDays > 0 {
+			similarity = models.DecaySimilarity{HalfLife: decayDays * 24 * 60 * 60, Now: a.now}
+		}
+		similar := a.similarAccounts(account, similarity, func(candidate models.Account) bool {
+			return (country == "" || candidate.Country == country) &&
+				(city == "" || candidate.City == city)
+		})
+		ids = a.collectSuggestions(id, vector, similar, limit, suggest)
+	}
+
+	accounts := models.Accounts{}
+	accounts.Accounts = make([]models.Account, 0)
+	for _, suggestedID := range ids {
+		suggested, _ := a.recommendIndex.Get(suggestedID)
+		accounts.Accounts = append(accounts.Accounts, shortProfile(suggested))
+	}
+
+	err = json.NewEncoder(w).Encode(accounts)
 	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
 		log.Println("[ERROR] ", err)
-		if err == mgo.ErrNotFound {
-			w.WriteHeader(http.StatusNotFound)
-			return
+	}
+}
+
+// similarAccounts returns the ids of the accepted accounts of the same sex
+// liking any of the account likees, most similar first and ascending ids
+// on equal similarity
+func (a *App) similarAccounts(account models.Account, similarity models.Similarity, accept func(models.Account) bool) []int {
+	candidates := make([]int, 0)
+	for _, candidateID := range a.likeIndex.Candidates(account.ID) {
+		candidate, ok := a.recommendIndex.Get(candidateID)
+		if !ok || candidate.Sex != account.Sex || !accept(candidate) {
+			continue
 		}
+		candidates = append(candidates, candidateID)
 	}
 
-	query := bson.M{"sex": account.Sex}
+	vector := a.likeIndex.Vector(account.ID)
+	similar := sortBySimilarity(candidates, func(candidateID int) float64 {
+		return similarity.Similarity(vector, a.likeIndex.Vector(candidateID))
+	})
+
+	ids := make([]int, len(similar))
+	for i, s := range similar {
+		ids[i] = s.id
+	}
+	return ids
+}
+
+// collectSuggestions returns up to limit unique ids liked by the similar accounts
+// and not liked by the account, in the order of the similar accounts and
+// descending inside the likes of every similar account. The account itself,
+// the ids missing in the dataset and the accounts not accepted are skipped.
+// seenPool keeps the sets of the ids collectSuggestions has looked at,
+// so that a request does not allocate a new map
+var seenPool = sync.Pool{
+	New: func() interface{} {
+		return make(map[int]bool)
+	},
+}
+
+func releaseSeen(seen map[int]bool) {
+	for id := range seen {
+		delete(seen, id)
+	}
+	seenPool.Put(seen)
+}
+
+func (a *App) collectSuggestions(id int, vector models.LikeVector, similar []int, limit int, accept func(models.Account) bool) []int {
+	ids := make([]int, 0, limit)
+	seen := seenPool.Get().(map[int]bool)
+	defer releaseSeen(seen)
+	for _, similarID := range similar {
+		for _, suggestedID := range vector.Missing(a.likeIndex.Vector(similarID)) {
+			if len(ids) == limit {
+				return ids
+			}
+			if suggestedID == id || seen[suggestedID] {
+				continue
+			}
+			seen[suggestedID] = true
+			if suggested, ok := a.recommendIndex.Get(suggestedID); !ok || !accept(suggested) {
+				continue
+			}
+			ids = append(ids, suggestedID)
+		}
+	}
+	return ids
+}
+
+func (a *App) matches(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := a.recommendIndex.Get(id); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
 
 	var limit int
+	var country, city string
 	for k, v := range r.URL.Query() {
 		if v[0] == "" {
 			w.WriteHeader(http.StatusBadRequest)
@@ -733,10 +1101,10 @@ func (a *App) suggest(w http.ResponseWriter, r *http.Request) {
 		}
 		switch k {
 		case "country":
-			query["country"] = v[0]
+			country = v[0]
 			continue
 		case "city":
-			query["city"] = v[0]
+			city = v[0]
 			continue
 		case "limit":
 			var err error
@@ -759,89 +1127,249 @@ func (a *App) suggest(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	likeIds := make([]int, 0)
-	for _, like := range account.Likes {
-		likeIds = append(likeIds, like.ID)
+	matches := models.LikedAccounts{}
+	matches.Accounts = make([]models.LikedAccount, 0)
+	for _, match := range a.likeIndex.Matches(id) {
+		if len(matches.Accounts) >= limit {
+			break
+		}
+		account, ok := a.recommendIndex.Get(match.ID)
+		if !ok {
+			continue
+		}
+		if country != "" && account.Country != country || city != "" && account.City != city {
+			continue
+		}
+		matches.Accounts = append(matches.Accounts, models.LikedAccount{Account: shortProfile(account), TS: match.TS})
 	}
-	query["likes"] = bson.M{"$elemMatch": bson.M{"id": bson.M{"$in": likeIds}}}
 
-	accounts := models.Accounts{}
-	accounts.Accounts = make([]models.Account, 0)
-
-	err = collection.Find(query).Select(bson.M{"likes": 1}).All(&accounts.Accounts)
+	err = json.NewEncoder(w).Encode(matches)
 	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
 		log.Println("[ERROR] ", err)
 	}
+}
 
-	account.PrepareLikesMap()
-	//sort.Slice(accounts.Accounts, func(i, j int) bool {
-	//	return account.CheckSimilarity(accounts.Accounts[i]) > account.CheckSimilarity(accounts.Accounts[j])
-	//})
-	parallelMergeSort(accounts.Accounts, account)
-
-	//ids := make([]int, 0)
-	//for _, a := range accounts.Accounts {
-	//	//log.Println(account.CheckSimilarity(a))todo
-	//	if account.CheckSimilarity(a) == 0 {
-	//		break
-	//	}
-	//	ids = append(ids, account.GetNewIds(a)...)
-	//}
-	//
-	//err = collection.Find(bson.M{"id": bson.M{"$in":ids}}).Select(bson.M{
-	//	"id":1,
-	//	"email":1,
-	//	"status":1,
-	//	"fname":1,
-	//	"sname":1}).All(&accounts.Accounts)
-	//if err != nil {
-	//	log.Println("[ERROR] ", err)
-	//}
-	//
-	//result := models.Accounts{}
-	//result.Accounts = make([]models.Account, 0)
-	//for i:= 0; i < limit; i++ {
-	//	tempAccount, err := accounts.ExtractAccountByID(ids[i])
-	//	if err != nil {
-	//		log.Println("[ERROR] ", err)
-	//		continue
-	//	}
-	//	result.Accounts = append(result.Accounts, tempAccount)
-	//}
-	//
-	//err = json.NewEncoder(w).Encode(result)
-	//if err != nil {
-	//	w.WriteHeader(http.StatusInternalServerError)
-	//	log.Println("[ERROR] ", err)
-	//}
-
-	//if len(accounts.Accounts) > limit {
-	//	accounts.Accounts = accounts.Accounts[:limit]
-	//}
-
-	ids := make([]int, 0)
-	for _, a := range accounts.Accounts {
-		ids = append(ids, account.GetNewIds(a)...)
-		if len(ids) > limit {
-			ids = ids[:limit]
-			break
+func (a *App) likers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := a.recommendIndex.Get(id); !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	//the page continues after the last like of the previous one,
+	//given by its timestamp and liker id
+	var limit, beforeTS, beforeID int
+	for k, v := range r.URL.Query() {
+		if v[0] == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch k {
+		case "before":
+			beforeTS, err = strconv.Atoi(v[0])
+			if err != nil {
+				log.Println("[ERROR] ", err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			continue
+		case "before_id":
+			beforeID, err = strconv.Atoi(v[0])
+			if err != nil {
+				log.Println("[ERROR] ", err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			continue
+		case "limit":
+			var err error
+			limit, err = strconv.Atoi(v[0])
+			if err != nil {
+				log.Println("[ERROR] ", err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if limit < 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			continue
+		case "query_id":
+			continue
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	if beforeID != 0 && beforeTS == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	likers := models.LikedAccounts{}
+	likers.Accounts = make([]models.LikedAccount, 0)
+	for _, like := range a.likeIndex.Likers(id, beforeTS, beforeID, limit) {
+		account, ok := a.recommendIndex.Get(like.ID)
+		if !ok {
+			continue
 		}
+		likers.Accounts = append(likers.Accounts, models.LikedAccount{Account: shortProfile(account), TS: like.TS})
 	}
 
-	err = collection.Find(bson.M{"id": bson.M{"$in": ids}}).Select(bson.M{
-		"id":     1,
-		"email":  1,
-		"status": 1,
-		"fname":  1,
-		"sname":  1}).Sort("-id").All(&accounts.Accounts)
+	err = json.NewEncoder(w).Encode(likers)
 	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
 		log.Println("[ERROR] ", err)
 	}
+}
 
-	err = json.NewEncoder(w).Encode(accounts)
+func (a *App) create(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	account := models.Account{}
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&account)
 	if err != nil {
+		log.Println("[ERROR] ", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	a.mutations.Lock()
+	defer a.mutations.Unlock()
+
+	if a.loadedIDs[account.ID] {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	err = a.validateAccount(account, true)
+	if err != nil {
+		log.Println("[ERROR] ", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = a.commit(storage.RecordAccountInsert, account)
+	if err != nil {
+		log.Println("[ERROR] ", err)
 		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write([]byte("{}"))
+}
+
+func (a *App) update(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	a.mutations.Lock()
+	defer a.mutations.Unlock()
+
+	if !a.loadedIDs[id] {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	//likes are only added by the likes endpoint
+	patch := models.Account{}
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&patch)
+	if err != nil || patch.ID != 0 || patch.Likes != nil {
+		log.Println("[ERROR] ", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	patch.ID = id
+	err = a.validateAccount(patch, false)
+	if err != nil {
+		log.Println("[ERROR] ", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = a.commit(storage.RecordAccountPatch, patch)
+	if err != nil {
 		log.Println("[ERROR] ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	_, _ = w.Write([]byte("{}"))
+}
+
+func (a *App) like(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	likes := models.NewLikes{}
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&likes)
+	if err != nil {
+		log.Println("[ERROR] ", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	a.mutations.Lock()
+	defer a.mutations.Unlock()
+
+	for _, like := range likes.Likes {
+		if like.TS <= 0 || !a.loadedIDs[like.Liker] || !a.loadedIDs[like.Likee] {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	if len(likes.Likes) > 0 {
+		err = a.commit(storage.RecordLikes, likes)
+		if err != nil {
+			log.Println("[ERROR] ", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
+	_, _ = w.Write([]byte("{}"))
+}
+
+// shortProfile keeps the basic profile fields of the account
+func shortProfile(account models.Account) models.Account {
+	return models.Account{
+		ID:     account.ID,
+		Email:  account.Email,
+		FName:  account.FName,
+		SName:  account.SName,
+		Status: account.Status,
+	}
+}
+
+// recommendProfile keeps the fields of a recommend result
+func recommendProfile(account models.Account) models.Account {
+	return models.Account{
+		ID:      account.ID,
+		Email:   account.Email,
+		Status:  account.Status,
+		Birth:   account.Birth,
+		Premium: account.Premium,
+		FName:   account.FName,
+		SName:   account.SName,
 	}
 }
 
@@ -855,6 +1383,123 @@ func exists(v string) bson.M {
 	return nil
 }
 
+// groupExpression returns the $group expression for the key,
+// computed keys are derived from the stored timestamps
+func (a *App) groupExpression(key string) interface{} {
+	switch key {
+	case "birth_year":
+		return yearExpression("$birth")
+	case "joined_year":
+		return yearExpression("$joined")
+	case "premium":
+		//the state is grouped by its rank so that the groups sort in the
+		//order of models.PremiumStates, the projection names it
+		return bson.M{"$switch": bson.M{
+			"branches": []bson.M{
+				{
+					"case": bson.M{"$eq": []interface{}{bson.M{"$ifNull": []interface{}{"$premium", nil}}, nil}},
+					"then": models.PremiumRank(models.PremiumNever),
+				},
+				{
+					"case": a.premiumNowExpression(),
+					"then": models.PremiumRank(models.PremiumActive),
+				},
+				{
+					"case": bson.M{"$lte": []interface{}{"$premium.finish", a.now}},
+					"then": models.PremiumRank(models.PremiumExpired),
+				},
+			},
+			"default": models.PremiumRank(models.PremiumNever),
+		}}
+	}
+	return "$" + key
+}
+
+// aggregateExpressions returns the name of the accumulated sum, its $group accumulator
+// and the $project expression computing the aggregate from the sum and count
+func (a *App) aggregateExpressions(aggregate string) (string, bson.M, bson.M) {
+	switch aggregate {
+	case "avg_age":
+		return "birth_sum", bson.M{"$sum": "$birth"}, bson.M{"$divide": []interface{}{
+			bson.M{"$subtract": []interface{}{a.now, bson.M{"$divide": []interface{}{"$birth_sum", "$count"}}}},
+			models.SecondsPerYear,
+		}}
+	case "premium_share":
+		return "premium_sum",
+			bson.M{"$sum": bson.M{"$cond": []interface{}{a.premiumNowExpression(), 1, 0}}},
+			bson.M{"$divide": []interface{}{"$premium_sum", "$count"}}
+	case "avg_likes_given":
+		return "likes_given_sum",
+			bson.M{"$sum": bson.M{"$size": bson.M{"$ifNull": []interface{}{"$likes", []interface{}{}}}}},
+			bson.M{"$divide": []interface{}{"$likes_given_sum", "$count"}}
+	case "avg_likes_received":
+		return "likes_received_sum",
+			bson.M{"$sum": "$likes_received"},
+			bson.M{"$divide": []interface{}{"$likes_received_sum", "$count"}}
+	}
+	return "", nil, nil
+}
+
+// premiumNowExpression is true when the premium window contains App.now
+func (a *App) premiumNowExpression() bson.M {
+	return bson.M{"$and": []bson.M{
+		{"$lt": []interface{}{"$premium.start", a.now}},
+		{"$gt": []interface{}{"$premium.finish", a.now}},
+	}}
+}
+
+// yearExpression converts the unix timestamp field to its UTC year
+func yearExpression(field string) bson.M {
+	return bson.M{"$year": bson.M{"$add": []interface{}{
+		time.Unix(0, 0).UTC(),
+		bson.M{"$multiply": []interface{}{field, 1000}},
+	}}}
+}
+
+// citiesWithin returns the cities around the city including itself,
+// a city missing in the gazetteer has no cities around
+func (a *App) citiesWithin(city string, km float64) []string {
+	cities, ok := a.gazetteer.Within(city, km)
+	if !ok {
+		if city == "" {
+			return []string{}
+		}
+		return []string{city}
+	}
+	return cities
+}
+
+func parseAge(v string) (int, error) {
+	age, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, err
+	}
+	if age < 0 {
+		return 0, errors.New("age must not be negative")
+	}
+	return age, nil
+}
+
+func parseKm(v string) (float64, error) {
+	km, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, err
+	}
+	if km <= 0 || math.IsNaN(km) || math.IsInf(km, 0) {
+		return 0, errors.New("within_km must be positive")
+	}
+	return km, nil
+}
+
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
 func yearInterval(year int) bson.M {
 	return bson.M{
 		"$gte": time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC).Unix(),
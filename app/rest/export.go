@@ -0,0 +1,210 @@
+package rest
+
+import (
+	"archive/zip"
+	"bufio"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hlc/app/models"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// export formats, both are read back by the loader
+const (
+	FormatNDJSON = "ndjson"
+	FormatZip    = "zip"
+
+	exportChunkSize = 10000 //accounts per file of the zip
+)
+
+var ErrExportFormat = errors.New("unknown export format")
+
+// ExportQuery builds the mongo query of the export from the filter predicates
+func (a *App) ExportQuery(params url.Values) (bson.M, error) {
+	query := bson.M{}
+	var withinKm float64
+	for k, v := range params {
+		if v[0] == "" {
+			return nil, errUnknownPredicate
+		}
+		switch k {
+		case "within_km":
+			var err error
+			withinKm, err = parseKm(v[0])
+			if err != nil {
+				return nil, err
+			}
+		case "query_id":
+		default:
+			err := a.parsePredicate(query, k, v[0])
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	//within_km widens city_eq to the cities around it
+	if withinKm > 0 {
+		city, ok := query["city"].(string)
+		if !ok || a.gazetteer == nil {
+			return nil, errors.New("within_km needs city_eq and the gazetteer")
+		}
+		query["city"] = bson.M{"$in": a.citiesWithin(city, withinKm)}
+	}
+	return query, nil
+}
+
+// Export streams the accounts matching the query ordered by id
+func (a *App) Export(w io.Writer, format string, query bson.M) error {
+	//the sort by id needs the index, without it mongo sorts in memory and fails past 32MB
+	err := a.EnsureIDIndex()
+	if err != nil {
+		return err
+	}
+
+	session := a.mongoSession.Copy()
+	defer session.Close()
+	collection := session.DB(dbName).C(accountsCollectionName)
+
+	iter := collection.Find(query).Sort("id").Iter()
+	switch format {
+	case FormatNDJSON:
+		err = exportNDJSON(w, iter.Next)
+	case FormatZip:
+		err = exportZip(w, iter.Next)
+	default:
+		err = ErrExportFormat
+	}
+	closeErr := iter.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+func exportNDJSON(w io.Writer, next func(result interface{}) bool) error {
+	out := bufio.NewWriter(w)
+	encoder := json.NewEncoder(out)
+	account := models.Account{}
+	for next(&account) {
+		err := encoder.Encode(account)
+		if err != nil {
+			return err
+		}
+		account = models.Account{}
+	}
+	return out.Flush()
+}
+
+// exportZip writes {"accounts":[...]} files of up to exportChunkSize accounts
+func exportZip(w io.Writer, next func(result interface{}) bool) error {
+	archive := zip.NewWriter(w)
+	var out io.Writer
+	n := 0
+	account := models.Account{}
+	for next(&account) {
+		separator := ","
+		if n%exportChunkSize == 0 {
+			if out != nil {
+				if _, err := io.WriteString(out, "]}\n"); err != nil {
+					return err
+				}
+			}
+			var err error
+			out, err = archive.Create(fmt.Sprintf("accounts_%d.json", n/exportChunkSize+1))
+			if err != nil {
+				return err
+			}
+			separator = `{"accounts":[`
+		}
+
+		data, err := json.Marshal(account)
+		if err != nil {
+			return err
+		}
+		if _, err = io.WriteString(out, separator); err != nil {
+			return err
+		}
+		if _, err = out.Write(data); err != nil {
+			return err
+		}
+		account = models.Account{}
+		n++
+	}
+
+	if out == nil {
+		var err error
+		out, err = archive.Create("accounts_1.json")
+		if err != nil {
+			return err
+		}
+		if _, err = io.WriteString(out, `{"accounts":[`); err != nil {
+			return err
+		}
+	}
+	if _, err := io.WriteString(out, "]}\n"); err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+func (a *App) export(w http.ResponseWriter, r *http.Request) {
+	//the export holds the emails and phones, it is served to the admin only
+	if a.adminToken == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !a.authorized(r) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	params := r.URL.Query()
+	format := FormatNDJSON
+	if v, ok := params["format"]; ok {
+		format = v[0]
+		delete(params, "format")
+	}
+
+	query, err := a.ExportQuery(params)
+	if err != nil {
+		log.Println("[ERROR] ", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	switch format {
+	case FormatNDJSON:
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	case FormatZip:
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", `attachment; filename="accounts.zip"`)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	//the status is sent with the first bytes, a later error only ends the stream
+	err = a.Export(w, format, query)
+	if err != nil {
+		log.Println("[ERROR] ", err)
+	}
+}
+
+// authorized checks the "Authorization: Bearer <token>" header against the admin token
+func (a *App) authorized(r *http.Request) bool {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return false
+	}
+	token := strings.TrimPrefix(header, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(a.adminToken)) == 1
+}
@@ -0,0 +1,148 @@
+package rest
+
+import (
+	"bytes"
+	"fmt"
+	"hlc/app/loader"
+	"hlc/app/models"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// accountsIter passes the accounts like the iterator of the database
+func accountsIter(accounts []models.Account) func(result interface{}) bool {
+	i := 0
+	return func(result interface{}) bool {
+		if i == len(accounts) {
+			return false
+		}
+		*result.(*models.Account) = accounts[i]
+		i++
+		return true
+	}
+}
+
+// readBack loads the exported data with the loader and returns the accounts with the files
+func readBack(t *testing.T, data []byte, name string) ([]models.Account, []string) {
+	dir, err := ioutil.TempDir("", "export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, name)
+	err = ioutil.WriteFile(path, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source, err := loader.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+	accounts := make([]models.Account, 0)
+	files := make([]string, 0)
+	for {
+		entry, err := source.Next()
+		if err == io.EOF {
+			return accounts, files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, entry.Name)
+		err = loader.Decode(entry, func(decoded []models.Account) error {
+			accounts = append(accounts, decoded...)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestExportRoundTrip(t *testing.T) {
+	_, fixture := fixtureApp(t)
+	many := make([]models.Account, 2*exportChunkSize+1)
+	for i := range many {
+		many[i] = models.Account{ID: i + 1, Email: fmt.Sprintf("user%d@mail.ru", i+1), Likes: []models.Like{{ID: i + 2, TS: i}}}
+	}
+
+	tests := []struct {
+		name     string
+		accounts []models.Account
+		files    []string
+	}{
+		{"fixture", fixture, []string{"accounts_1.json"}},
+		{"empty", []models.Account{}, []string{"accounts_1.json"}},
+		{"chunks", many, []string{"accounts_1.json", "accounts_2.json", "accounts_3.json"}},
+	}
+	for _, tt := range tests {
+		var ndjson bytes.Buffer
+		err := exportNDJSON(&ndjson, accountsIter(tt.accounts))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := readBack(t, ndjson.Bytes(), "accounts.ndjson"); !reflect.DeepEqual(got, tt.accounts) {
+			t.Errorf("%s: ndjson read back %d accounts, want %d equal ones", tt.name, len(got), len(tt.accounts))
+		}
+
+		var zipped bytes.Buffer
+		err = exportZip(&zipped, accountsIter(tt.accounts))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, files := readBack(t, zipped.Bytes(), "accounts.zip")
+		if !reflect.DeepEqual(got, tt.accounts) {
+			t.Errorf("%s: zip read back %d accounts, want %d equal ones", tt.name, len(got), len(tt.accounts))
+		}
+		if !reflect.DeepEqual(files, tt.files) {
+			t.Errorf("%s: zip files %v, want %v", tt.name, files, tt.files)
+		}
+	}
+}
+
+// The export is answered before the database is queried when it is not allowed.
+func TestExportAdminToken(t *testing.T) {
+	a := &App{}
+	a.initialize()
+
+	request := func(url, authorization string) int {
+		r := httptest.NewRequest("GET", url, nil)
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		a.router.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := request("/admin/export", "Bearer "); code != http.StatusNotFound {
+		t.Errorf("no admin token: status %d, want %d", code, http.StatusNotFound)
+	}
+
+	a.SetAdminToken("secret")
+	tests := []struct {
+		url           string
+		authorization string
+		code          int
+	}{
+		{"/admin/export", "", http.StatusUnauthorized},
+		{"/admin/export/", "Bearer wrong", http.StatusUnauthorized},
+		{"/admin/export", "secret", http.StatusUnauthorized},
+		{"/admin/export", "Bearer secre", http.StatusUnauthorized},
+		{"/admin/export?format=csv", "Bearer secret", http.StatusBadRequest},
+		{"/admin/export?unknown=1", "Bearer secret", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if code := request(tt.url, tt.authorization); code != tt.code {
+			t.Errorf("%s %q: status %d, want %d", tt.url, tt.authorization, code, tt.code)
+		}
+	}
+}
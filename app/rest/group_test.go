@@ -0,0 +1,163 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"hlc/app/models"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+func TestGroupFromIndex(t *testing.T) {
+	a := &App{}
+	tests := []struct {
+		params string
+		index  bool
+	}{
+		{"keys=sex&aggregates=avg_age", true},
+		{"keys=sex&aggregates=avg_age&country=a&birth=1990&interests=x", true},
+		{"keys=city&aggregates=avg_age&likes=1", true},
+		{"keys=sex", false},
+		{"keys=sex&aggregates=avg_age&sname_starts=a", false},
+		{"keys=sex&aggregates=avg_age&likes=1&city_eq=a", false},
+		{"keys=interests&aggregates=avg_age&interests=x", false},
+	}
+	for _, tt := range tests {
+		values, err := url.ParseQuery(tt.params + "&order=1&limit=10")
+		if err != nil {
+			t.Fatal(err)
+		}
+		request, err := a.groupQuery(values)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.params, err)
+		}
+		if request.fromIndex() != tt.index {
+			t.Errorf("%s: from index %v, want %v", tt.params, request.fromIndex(), tt.index)
+		}
+	}
+}
+
+func TestGroupRequiresOrderAndLimit(t *testing.T) {
+	a, _ := fixtureApp(t)
+	for _, params := range []string{"keys=sex&limit=5", "keys=sex&order=1", "keys=sex&aggregates=avg_age&order=1"} {
+		if w := serve(a, "GET", "/accounts/group/?"+params); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want %d", params, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+// The groups of the likers come from the group index of the likers,
+// the likes filter does not go to the database.
+func TestGroupLikesFromIndex(t *testing.T) {
+	a, accounts := fixtureApp(t)
+
+	//the most liked account has the most groups
+	received := make(map[int]int)
+	likee := 0
+	for _, account := range accounts {
+		for _, like := range account.Likes {
+			received[like.ID]++
+			if received[like.ID] > received[likee] {
+				likee = like.ID
+			}
+		}
+	}
+	want := models.NewGroupIndex()
+	for _, account := range accounts {
+		for _, like := range account.Likes {
+			if like.ID == likee {
+				want.Put(account)
+			}
+		}
+	}
+
+	q := models.GroupQuery{
+		Keys:       []string{"sex", "status"},
+		Aggregates: []string{"avg_age", "avg_likes_given"},
+		SortBy:     "avg_likes_given",
+		Order:      -1,
+		Limit:      10,
+	}
+	w := serve(a, "GET", fmt.Sprintf("/accounts/group/?likes=%d&keys=sex,status&aggregates=avg_age,avg_likes_given&sort_by=avg_likes_given&order=-1&limit=10", likee))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d", w.Code)
+	}
+	got := models.Groups{}
+	err := json.Unmarshal(w.Body.Bytes(), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := want.Groups(q)
+	if len(expected) == 0 {
+		t.Fatal("no likers in the fixture")
+	}
+	if !reflect.DeepEqual(got.Groups, expected) {
+		t.Errorf("groups = %v, want %v", got.Groups, expected)
+	}
+}
+
+// The requests the group index does not answer are aggregated by the database.
+func TestGroupPipeline(t *testing.T) {
+	a := &App{}
+	values, err := url.ParseQuery("keys=interests,sex&aggregates=avg_age&sort_by=avg_age&order=-1&limit=3&sname_starts=a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	request, err := a.groupQuery(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipeline := a.groupPipeline(request)
+
+	stages := make([]string, len(pipeline))
+	for i, stage := range pipeline {
+		for name := range stage {
+			stages[i] = name
+		}
+	}
+	if want := []string{"$match", "$unwind", "$group", "$project", "$sort", "$limit"}; !reflect.DeepEqual(stages, want) {
+		t.Fatalf("stages %v, want %v", stages, want)
+	}
+	if !reflect.DeepEqual(pipeline[0]["$match"], bson.M{"sname": bson.M{"$regex": "^a"}}) {
+		t.Errorf("$match = %v", pipeline[0]["$match"])
+	}
+	sort := bson.D{{Name: "avg_age", Value: -1}, {Name: "interests", Value: -1}, {Name: "sex", Value: -1}}
+	if !reflect.DeepEqual(pipeline[4]["$sort"], sort) {
+		t.Errorf("$sort = %v, want %v", pipeline[4]["$sort"], sort)
+	}
+	if pipeline[5]["$limit"] != 3 {
+		t.Errorf("$limit = %v, want 3", pipeline[5]["$limit"])
+	}
+	group := pipeline[2]["$group"].(bson.M)
+	if _, ok := group["birth_sum"]; !ok {
+		t.Errorf("$group = %v, want the birth sum", group)
+	}
+}
+
+// The premium groups are sorted by the rank of the state and not its name.
+func TestGroupPipelinePremiumOrder(t *testing.T) {
+	a := &App{}
+	values, err := url.ParseQuery("keys=premium&order=1&limit=3&sname_starts=a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	request, err := a.groupQuery(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipeline := a.groupPipeline(request)
+
+	sort := bson.D{{Name: "count", Value: 1}, {Name: "premium_rank", Value: 1}}
+	if !reflect.DeepEqual(pipeline[3]["$sort"], sort) {
+		t.Errorf("$sort = %v, want %v", pipeline[3]["$sort"], sort)
+	}
+	project := pipeline[2]["$project"].(bson.M)
+	name := bson.M{"$arrayElemAt": []interface{}{models.PremiumStates, "$_id.premium"}}
+	if !reflect.DeepEqual(project["premium"], name) || project["premium_rank"] != "$_id.premium" {
+		t.Errorf("$project = %v", project)
+	}
+}
@@ -0,0 +1,144 @@
+package rest
+
+import (
+	"encoding/json"
+	"hlc/app/models"
+	"hlc/app/storage"
+	"log"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+const (
+	importsCollectionName = "imports" //keeps the hashes of the imported data files
+	indexBatchSize        = 10000     //accounts indexed by IndexCollection at once
+)
+
+type importedFile struct {
+	Name string `bson:"name"`
+	Hash string `bson:"hash"`
+}
+
+// IndexCollection builds the in-memory indexes from the accounts
+// already stored, it replaces the load when the data is kept
+func (a *App) IndexCollection() error {
+	session := a.mongoSession.Copy()
+	defer session.Close()
+	collection := session.DB(dbName).C(accountsCollectionName)
+
+	iter := collection.Find(nil).Iter()
+	batch := make([]models.Account, 0, indexBatchSize)
+	account := models.Account{}
+	n := 0
+	for iter.Next(&account) {
+		a.loadedIDs[account.ID] = true
+		a.recommendIndex.Put(account)
+		a.groupIndex.Put(account)
+		batch = append(batch, account)
+		if len(batch) == indexBatchSize {
+			a.likeIndex.AddAccounts(batch)
+			batch = batch[:0]
+		}
+		account = models.Account{}
+		n++
+	}
+	a.likeIndex.AddAccounts(batch)
+	err := iter.Close()
+	if err != nil {
+		return err
+	}
+	log.Println("[INFO] accounts indexed=", n)
+	return nil
+}
+
+// ImportData upserts the accounts by id, the fields set in the imported
+// account replace the stored ones and the likes are merged
+func (a *App) ImportData(accounts []models.Account) error {
+	a.mutations.Lock()
+	defer a.mutations.Unlock()
+
+	//the accounts are logged as inserts, which upsert on replay,
+	//so that the replay over the kept data does not revert the import
+	if a.wal != nil {
+		for _, account := range accounts {
+			payload, err := json.Marshal(account)
+			if err != nil {
+				return err
+			}
+			seq, err := a.wal.Append(storage.RecordAccountInsert, payload)
+			if err != nil {
+				return err
+			}
+			a.walSeq = seq
+		}
+	}
+	return a.importAccounts(accounts)
+}
+
+func (a *App) importAccounts(accounts []models.Account) error {
+	fresh := make([]models.Account, 0, len(accounts))
+	pending := make(map[int]bool)
+	for _, account := range accounts {
+		if pending[account.ID] {
+			//the account repeats in the batch, it is inserted before it is merged
+			err := a.insertAccounts(fresh)
+			if err != nil {
+				return err
+			}
+			fresh = fresh[:0]
+			pending = make(map[int]bool)
+		}
+		if !a.loadedIDs[account.ID] {
+			fresh = append(fresh, account)
+			pending[account.ID] = true
+			continue
+		}
+		err := a.mergeAccount(account)
+		if err != nil {
+			return err
+		}
+	}
+	return a.insertAccounts(fresh)
+}
+
+func (a *App) mergeAccount(account models.Account) error {
+	likes := make([]models.NewLike, 0, len(account.Likes))
+	for _, like := range account.Likes {
+		likes = append(likes, models.NewLike{Likee: like.ID, TS: like.TS, Liker: account.ID})
+	}
+	account.Likes = nil
+
+	err := a.patchAccount(account)
+	if err != nil {
+		return err
+	}
+	return a.addLikes(likes)
+}
+
+// Imported reports whether the data file with the hash is imported already
+func (a *App) Imported(name, hash string) (bool, error) {
+	session := a.mongoSession.Copy()
+	defer session.Close()
+	collection := session.DB(dbName).C(importsCollectionName)
+
+	file := importedFile{}
+	err := collection.Find(bson.M{"name": name}).One(&file)
+	if err == mgo.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return file.Hash == hash, nil
+}
+
+// MarkImported records the hash of the imported data file
+func (a *App) MarkImported(name, hash string) error {
+	session := a.mongoSession.Copy()
+	defer session.Close()
+	collection := session.DB(dbName).C(importsCollectionName)
+
+	_, err := collection.Upsert(bson.M{"name": name}, importedFile{Name: name, Hash: hash})
+	return err
+}
@@ -0,0 +1,74 @@
+package rest
+
+import (
+	"encoding/json"
+	"hlc/app/models"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestMatches(t *testing.T) {
+	a := &App{}
+	a.initialize()
+	accounts := []models.Account{
+		{ID: 1, Email: "a@mail.ru", Likes: []models.Like{{ID: 2, TS: 10}, {ID: 3, TS: 20}, {ID: 4, TS: 5}}},
+		{ID: 2, Email: "b@mail.ru", Country: "Россия", City: "Москва", Likes: []models.Like{{ID: 1, TS: 30}}},
+		{ID: 3, Email: "c@mail.ru", Country: "Россия", City: "Казань", Likes: []models.Like{{ID: 1, TS: 15}}},
+		{ID: 4, Email: "d@mail.ru", Country: "Испания", Likes: []models.Like{{ID: 1, TS: 40}}},
+		{ID: 5, Email: "e@mail.ru", Country: "Россия", Likes: []models.Like{{ID: 1, TS: 50}}},
+	}
+	for _, account := range accounts {
+		a.recommendIndex.Put(account)
+	}
+	a.likeIndex.AddAccounts(accounts)
+
+	tests := []struct {
+		params string
+		want   []models.LikedAccount
+	}{
+		{"limit=10", []models.LikedAccount{
+			{Account: models.Account{ID: 4, Email: "d@mail.ru"}, TS: 40},
+			{Account: models.Account{ID: 2, Email: "b@mail.ru"}, TS: 30},
+			{Account: models.Account{ID: 3, Email: "c@mail.ru"}, TS: 20},
+		}},
+		{"limit=2", []models.LikedAccount{
+			{Account: models.Account{ID: 4, Email: "d@mail.ru"}, TS: 40},
+			{Account: models.Account{ID: 2, Email: "b@mail.ru"}, TS: 30},
+		}},
+		{"limit=10&country=Россия", []models.LikedAccount{
+			{Account: models.Account{ID: 2, Email: "b@mail.ru"}, TS: 30},
+			{Account: models.Account{ID: 3, Email: "c@mail.ru"}, TS: 20},
+		}},
+		{"limit=10&city=Казань", []models.LikedAccount{
+			{Account: models.Account{ID: 3, Email: "c@mail.ru"}, TS: 20},
+		}},
+		{"limit=10&city=Мадрид", []models.LikedAccount{}},
+	}
+	for _, tt := range tests {
+		w := serve(a, "GET", "/accounts/1/matches/?"+tt.params)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d", tt.params, w.Code)
+		}
+		got := models.LikedAccounts{}
+		err := json.Unmarshal(w.Body.Bytes(), &got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got.Accounts, tt.want) {
+			t.Errorf("%s: matches %+v, want %+v", tt.params, got.Accounts, tt.want)
+		}
+	}
+
+	statuses := map[string]int{
+		"/accounts/9/matches/?limit=1":       http.StatusNotFound,
+		"/accounts/1/matches/?limit=-1":      http.StatusBadRequest,
+		"/accounts/1/matches/?limit=1&sex=m": http.StatusBadRequest,
+		"/accounts/1/matches/?limit=":        http.StatusBadRequest,
+	}
+	for url, code := range statuses {
+		if w := serve(a, "GET", url); w.Code != code {
+			t.Errorf("%s: status %d, want %d", url, w.Code, code)
+		}
+	}
+}
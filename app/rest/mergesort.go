@@ -1,12 +1,79 @@
 package rest
 
 import (
-	"hlc/app/models"
+	"runtime"
+	"sort"
 	"sync"
 )
 
-func merge(s []models.Account, middle int, a models.Account) {
-	helper := make([]models.Account, len(s))
+// slices shorter than sequentialCutoff are sorted in the calling goroutine
+const sequentialCutoff = 2048
+
+type similarAccount struct {
+	similarity float64
+	id         int
+}
+
+// sortBySimilarity orders the account ids by their similarity, most similar first,
+// keeping the order of equally similar accounts, so ascending ids stay ascending
+// on equal similarity. Every similarity is computed once.
+func sortBySimilarity(s []int, similarity func(id int) float64) []similarAccount {
+	workers := runtime.NumCPU()
+	similar := make([]similarAccount, len(s))
+
+	var wg sync.WaitGroup
+	chunk := (len(s) + workers - 1) / workers
+	for start := 0; start < len(s); start += chunk {
+		end := start + chunk
+		if end > len(s) {
+			end = len(s)
+		}
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				similar[i] = similarAccount{similarity: similarity(s[i]), id: s[i]}
+			}
+		}(start, end)
+	}
+	wg.Wait()
+
+	//the semaphore bounds the number of goroutines sorting at once
+	semaphore := make(chan struct{}, workers)
+	parallelMergeSort(similar, make([]similarAccount, len(similar)), semaphore)
+	return similar
+}
+
+func parallelMergeSort(s, helper []similarAccount, semaphore chan struct{}) {
+	if len(s) < sequentialCutoff {
+		sort.SliceStable(s, func(i, j int) bool {
+			return s[i].similarity > s[j].similarity
+		})
+		return
+	}
+
+	middle := len(s) / 2
+	select {
+	case semaphore <- struct{}{}:
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			parallelMergeSort(s[:middle], helper[:middle], semaphore)
+		}()
+		parallelMergeSort(s[middle:], helper[middle:], semaphore)
+		wg.Wait()
+	default:
+		parallelMergeSort(s[:middle], helper[:middle], semaphore)
+		parallelMergeSort(s[middle:], helper[middle:], semaphore)
+	}
+	merge(s, helper, middle)
+}
+
+// merge joins the sorted halves of s using helper as the buffer,
+// the left half wins on equal similarity to keep the sort stable
+func merge(s, helper []similarAccount, middle int) {
 	copy(helper, s)
 
 	helperLeft := 0
@@ -15,7 +82,7 @@ func merge(s []models.Account, middle int, a models.Account) {
 	high := len(s) - 1
 
 	for helperLeft <= middle-1 && helperRight <= high {
-		if a.CheckSimilarity(helper[helperLeft]) >= a.CheckSimilarity(helper[helperRight]) {
+		if helper[helperLeft].similarity >= helper[helperRight].similarity {
 			s[current] = helper[helperLeft]
 			helperLeft++
 		} else {
@@ -31,27 +98,3 @@ func merge(s []models.Account, middle int, a models.Account) {
 		helperLeft++
 	}
 }
-
-func parallelMergeSort(s []models.Account, a models.Account) {
-	length := len(s)
-
-	if length > 1 {
-		middle := length / 2
-
-		var wg sync.WaitGroup
-		wg.Add(2)
-
-		go func() {
-			defer wg.Done()
-			parallelMergeSort(s[:middle], a)
-		}()
-
-		go func() {
-			defer wg.Done()
-			parallelMergeSort(s[middle:], a)
-		}()
-
-		wg.Wait()
-		merge(s, middle, a)
-	}
-}
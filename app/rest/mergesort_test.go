@@ -0,0 +1,130 @@
+package rest
+
+import (
+	"fmt"
+	"hlc/app/models"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+// oldMerge and oldParallelMergeSort are the sort replaced by sortBySimilarity:
+// a goroutine for every half, a buffer for every merge and the similarity
+// computed on every comparison
+func oldMerge(s []int, middle int, similarity func(id int) float64) {
+	helper := make([]int, len(s))
+	copy(helper, s)
+
+	helperLeft := 0
+	helperRight := middle
+	current := 0
+	high := len(s) - 1
+
+	for helperLeft <= middle-1 && helperRight <= high {
+		if similarity(helper[helperLeft]) >= similarity(helper[helperRight]) {
+			s[current] = helper[helperLeft]
+			helperLeft++
+		} else {
+			s[current] = helper[helperRight]
+			helperRight++
+		}
+		current++
+	}
+
+	for helperLeft <= middle-1 {
+		s[current] = helper[helperLeft]
+		current++
+		helperLeft++
+	}
+}
+
+func oldParallelMergeSort(s []int, similarity func(id int) float64) {
+	length := len(s)
+
+	if length > 1 {
+		middle := length / 2
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+			oldParallelMergeSort(s[:middle], similarity)
+		}()
+
+		go func() {
+			defer wg.Done()
+			oldParallelMergeSort(s[middle:], similarity)
+		}()
+
+		wg.Wait()
+		oldMerge(s, middle, similarity)
+	}
+}
+
+// similarityFixture returns n ascending candidate ids and the similarity of
+// their like vectors to a random account, the vectors share few likees so
+// that the similarities tie often
+func similarityFixture(n int) ([]int, func(id int) float64) {
+	random := rand.New(rand.NewSource(1))
+	randomVector := func() models.LikeVector {
+		likes := make([]models.Like, 20)
+		for i := range likes {
+			likes[i] = models.Like{ID: random.Intn(200), TS: random.Intn(1000)}
+		}
+		return models.NewLikeVector(likes)
+	}
+
+	account := randomVector()
+	vectors := make(map[int]models.LikeVector, n)
+	ids := make([]int, n)
+	for i := range ids {
+		ids[i] = i + 1
+		vectors[ids[i]] = randomVector()
+	}
+	return ids, func(id int) float64 {
+		return account.Similarity(vectors[id])
+	}
+}
+
+func TestSortBySimilarityMatchesOldSort(t *testing.T) {
+	for _, n := range []int{0, 1, 100, sequentialCutoff - 1, sequentialCutoff, 3 * sequentialCutoff} {
+		ids, similarity := similarityFixture(n)
+		want := append([]int{}, ids...)
+		oldParallelMergeSort(want, similarity)
+
+		similar := sortBySimilarity(ids, similarity)
+		for i := range similar {
+			if similar[i].id != want[i] {
+				t.Fatalf("n=%d: id %d at %d, want %d", n, similar[i].id, i, want[i])
+			}
+		}
+	}
+}
+
+// The sizes are on both sides of sequentialCutoff.
+var benchmarkSizes = []int{sequentialCutoff / 4, sequentialCutoff - 1, sequentialCutoff, 8 * sequentialCutoff}
+
+func BenchmarkSortBySimilarity(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		ids, similarity := similarityFixture(n)
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				sortBySimilarity(ids, similarity)
+			}
+		})
+	}
+}
+
+func BenchmarkOldParallelMergeSort(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		ids, similarity := similarityFixture(n)
+		s := make([]int, n)
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				copy(s, ids)
+				oldParallelMergeSort(s, similarity)
+			}
+		})
+	}
+}
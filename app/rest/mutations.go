@@ -0,0 +1,264 @@
+package rest
+
+import (
+	"encoding/json"
+	"errors"
+	"hlc/app/models"
+	"hlc/app/storage"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+var errUnknownRecord = errors.New("unknown wal record type")
+
+// OpenWAL starts logging the mutations to the file,
+// the records are numbered after the last applied one
+func (a *App) OpenWAL(path string, policy storage.SyncPolicy, interval time.Duration) error {
+	a.mutations.Lock()
+	defer a.mutations.Unlock()
+
+	wal, err := storage.OpenWAL(path, a.walSeq, policy, interval)
+	if err != nil {
+		return err
+	}
+	a.wal = wal
+	return nil
+}
+
+func (a *App) CloseWAL() error {
+	a.mutations.Lock()
+	defer a.mutations.Unlock()
+
+	if a.wal == nil {
+		return nil
+	}
+	err := a.wal.Close()
+	a.wal = nil
+	return err
+}
+
+// ReplayWAL applies the logged mutations made after the loaded snapshot or data file.
+// The mutations were validated before they were logged, a record failing to apply
+// is logged and skipped so that it can not keep the server from starting.
+func (a *App) ReplayWAL(path string) error {
+	a.mutations.Lock()
+	defer a.mutations.Unlock()
+
+	from := a.walSeq
+	failed := 0
+	seq, err := storage.ReplayWAL(path, a.walSeq, func(seq uint64, kind storage.RecordType, payload []byte) error {
+		err := a.applyMutation(kind, payload)
+		if err != nil {
+			failed++
+			log.Println("[ERROR] wal record seq=", seq, "skipped:", err)
+		}
+		return nil
+	})
+	a.walSeq = seq
+	if err != nil {
+		return err
+	}
+	log.Println("[INFO] wal replayed from", path, "records=", seq-from, "failed=", failed)
+	return nil
+}
+
+// SkipWAL moves the applied sequence to the last logged mutation without applying
+// the log, the stored accounts have its mutations already
+func (a *App) SkipWAL(path string) error {
+	a.mutations.Lock()
+	defer a.mutations.Unlock()
+
+	seq, err := storage.LastWALSeq(path)
+	if err != nil {
+		return err
+	}
+	a.walSeq = seq
+	return nil
+}
+
+// commit logs the mutation and applies it, the caller holds the mutations lock and
+// has validated the mutation. A mutation failing to apply stays in the log,
+// the replay retries it.
+func (a *App) commit(kind storage.RecordType, mutation interface{}) error {
+	payload, err := json.Marshal(mutation)
+	if err != nil {
+		return err
+	}
+	if a.wal != nil {
+		seq, err := a.wal.Append(kind, payload)
+		if err != nil {
+			return err
+		}
+		a.walSeq = seq
+	}
+	return a.applyMutation(kind, payload)
+}
+
+// applyMutation applies a validated mutation, it is shared by the handlers and the replay
+func (a *App) applyMutation(kind storage.RecordType, payload []byte) error {
+	switch kind {
+	case storage.RecordAccountInsert:
+		account := models.Account{}
+		err := json.Unmarshal(payload, &account)
+		if err != nil {
+			return err
+		}
+		//the account may be stored already when the wal is replayed over kept data
+		return a.importAccounts([]models.Account{account})
+	case storage.RecordAccountPatch:
+		account := models.Account{}
+		err := json.Unmarshal(payload, &account)
+		if err != nil {
+			return err
+		}
+		return a.patchAccount(account)
+	case storage.RecordLikes:
+		likes := models.NewLikes{}
+		err := json.Unmarshal(payload, &likes)
+		if err != nil {
+			return err
+		}
+		return a.addLikes(likes.Likes)
+	}
+	return errUnknownRecord
+}
+
+// patchAccount sets the non-empty fields of the patch and reindexes the account
+func (a *App) patchAccount(patch models.Account) error {
+	session := a.mongoSession.Copy()
+	defer session.Close()
+	collection := session.DB(dbName).C(accountsCollectionName)
+
+	fields, err := patchFields(patch)
+	if err != nil {
+		return err
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+
+	err = collection.Update(bson.M{"id": patch.ID}, bson.M{"$set": fields})
+	if err != nil {
+		return err
+	}
+
+	account := models.Account{}
+	err = collection.Find(bson.M{"id": patch.ID}).One(&account)
+	if err != nil {
+		return err
+	}
+	a.recommendIndex.Put(account)
+	a.groupIndex.Put(account)
+	return nil
+}
+
+// patchFields returns the non-empty fields of the patch except the id and the likes
+func patchFields(patch models.Account) (bson.M, error) {
+	data, err := bson.Marshal(patch)
+	if err != nil {
+		return nil, err
+	}
+	fields := bson.M{}
+	err = bson.Unmarshal(data, fields)
+	if err != nil {
+		return nil, err
+	}
+	delete(fields, "id")
+	delete(fields, "likes")
+	return fields, nil
+}
+
+// addLikes appends the likes to their likers and counts them for the likees,
+// the likes the like index has already are skipped
+func (a *App) addLikes(likes []models.NewLike) error {
+	session := a.mongoSession.Copy()
+	defer session.Close()
+	collection := session.DB(dbName).C(accountsCollectionName)
+
+	byLiker := make(map[int][]models.Like)
+	likers := make([]int, 0)
+	for _, like := range likes {
+		if _, ok := byLiker[like.Liker]; !ok {
+			likers = append(likers, like.Liker)
+		}
+		byLiker[like.Liker] = append(byLiker[like.Liker], models.Like{ID: like.Likee, TS: like.TS})
+	}
+
+	bulk := collection.Bulk()
+	bulk.Unordered()
+	added := make(map[int][]models.Like, len(likers))
+	for _, liker := range likers {
+		missing := a.likeIndex.Missing(liker, byLiker[liker])
+		if len(missing) == 0 {
+			continue
+		}
+		added[liker] = missing
+
+		bulk.Update(bson.M{"id": liker}, bson.M{"$push": bson.M{"likes": bson.M{"$each": missing}}})
+		for _, like := range missing {
+			if a.loadedIDs[like.ID] {
+				bulk.Update(bson.M{"id": like.ID}, bson.M{"$inc": bson.M{"likes_received": 1}})
+			}
+		}
+	}
+	_, err := bulk.Run()
+	if err != nil {
+		return err
+	}
+
+	//the indexes follow the database only once it has the likes
+	for _, liker := range likers {
+		if likes, ok := added[liker]; ok {
+			a.likeIndex.Add(liker, likes)
+			a.recommendIndex.AddLiked(liker, likes)
+			a.groupIndex.AddLikes(liker, likes)
+		}
+	}
+	return nil
+}
+
+// validateAccount checks the fields set in the account, all required
+// fields must be set when a new account is validated
+func (a *App) validateAccount(account models.Account, create bool) error {
+	if create && (account.ID <= 0 || account.Email == "" || account.Sex == "" || account.Birth == 0 || account.Status == "") {
+		return errors.New("required account field is missing")
+	}
+	if account.Email != "" && !strings.Contains(account.Email, "@") {
+		return errors.New("bad email " + account.Email)
+	}
+	if account.Sex != "" && account.Sex != "m" && account.Sex != "f" {
+		return errors.New("bad sex " + account.Sex)
+	}
+	if account.Status != "" && !contains(models.Statuses, account.Status) {
+		return errors.New("bad status " + account.Status)
+	}
+	if account.Premium != nil && account.Premium.Start >= account.Premium.Finish {
+		return errors.New("premium must start before it finishes")
+	}
+	if account.AgeMin < 0 || account.AgeMax < 0 || account.AgeMax != 0 && account.AgeMin > account.AgeMax {
+		return errors.New("bad age preference")
+	}
+	for _, like := range account.Likes {
+		if like.TS <= 0 || !a.loadedIDs[like.ID] {
+			return errors.New("bad like")
+		}
+	}
+
+	if account.Email != "" {
+		session := a.mongoSession.Copy()
+		defer session.Close()
+		collection := session.DB(dbName).C(accountsCollectionName)
+
+		n, err := collection.Find(bson.M{"email": account.Email, "id": bson.M{"$ne": account.ID}}).Count()
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			return errors.New("email is taken " + account.Email)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package rest
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+var errUnknownPredicate = errors.New("unknown predicate")
+
+// parsePredicate adds the filter predicate k=v to the mongo query.
+// The same vocabulary is accepted by /accounts/filter/ and /accounts/group/
+func (a *App) parsePredicate(query bson.M, k, v string) error {
+	switch k {
+	case "sex_eq":
+		setCondition(query, "sex", v)
+	case "email_domain":
+		setCondition(query, "email", bson.M{"$regex": "(@" + v + ")"})
+	case "email_lt":
+		addCondition(query, "email", "$lt", v)
+	case "email_gt":
+		addCondition(query, "email", "$gt", v)
+	case "status_eq":
+		setCondition(query, "status", v)
+	case "status_neq":
+		setCondition(query, "status", bson.M{"$ne": v})
+	case "fname_eq":
+		setCondition(query, "fname", v)
+	case "fname_any":
+		setCondition(query, "fname", bson.M{"$in": strings.Split(v, ",")})
+	case "fname_null":
+		setCondition(query, "fname", exists(v))
+	case "sname_eq":
+		setCondition(query, "sname", v)
+	case "sname_starts":
+		setCondition(query, "sname", bson.M{"$regex": "^" + v})
+	case "sname_null":
+		setCondition(query, "sname", exists(v))
+	case "phone_code":
+		setCondition(query, "phone", bson.M{"$regex": "(\\(" + v + "\\))"})
+	case "phone_null":
+		setCondition(query, "phone", exists(v))
+	case "country_eq":
+		setCondition(query, "country", v)
+	case "country_null":
+		setCondition(query, "country", exists(v))
+	case "city_eq":
+		setCondition(query, "city", v)
+	case "city_any":
+		setCondition(query, "city", bson.M{"$in": strings.Split(v, ",")})
+	case "city_null":
+		setCondition(query, "city", exists(v))
+	case "birth_lt":
+		birth, err := strconv.Atoi(v)
+		if err != nil {
+			log.Println("[ERROR] ", err)
+			return err
+		}
+		addCondition(query, "birth", "$lt", birth)
+	case "birth_gt":
+		birth, err := strconv.Atoi(v)
+		if err != nil {
+			log.Println("[ERROR] ", err)
+			return err
+		}
+		addCondition(query, "birth", "$gt", birth)
+	case "birth_year":
+		year, err := strconv.Atoi(v)
+		if err != nil {
+			log.Println("[ERROR] ", err)
+			return err
+		}
+		setCondition(query, "birth", yearInterval(year))
+	case "interests_contains":
+		setCondition(query, "interests", bson.M{"$all": strings.Split(v, ",")})
+	case "interests_any":
+		setCondition(query, "interests", bson.M{"$elemMatch": bson.M{"$in": strings.Split(v, ",")}})
+	case "likes_contains":
+		likes := strings.Split(v, ",")
+		likeIds := make([]int, 0)
+		for _, like := range likes {
+			l, err := strconv.Atoi(like)
+			if err != nil {
+				log.Println("[ERROR] ", err)
+				return err
+			}
+			likeIds = append(likeIds, l)
+		}
+		//log.Println("[DEBUG] ", likeIds)
+		setCondition(query, "likes", bson.M{"$elemMatch": bson.M{"id": bson.M{"$all": likeIds}}})
+	case "premium_now":
+		and, _ := query["$and"].([]bson.M)
+		query["$and"] = append(and, bson.M{"premium.start": bson.M{"$lt": a.now}}, bson.M{"premium.finish": bson.M{"$gt": a.now}})
+	case "premium_null":
+		setCondition(query, "premium", exists(v))
+	default:
+		return errUnknownPredicate
+	}
+	return nil
+}
+
+// addCondition merges the comparison into the conditions already set for the field,
+// so that predicates like birth_gt and birth_lt can be combined
+func addCondition(query bson.M, field, op string, value interface{}) {
+	if conditions, ok := query[field].(bson.M); ok {
+		if _, ok := conditions[op]; !ok {
+			conditions[op] = value
+			return
+		}
+	}
+	setCondition(query, field, bson.M{op: value})
+}
+
+// setCondition sets the condition of the field, a field which already has one
+// gets the new condition in $and, so that predicates on the same field intersect
+func setCondition(query bson.M, field string, condition interface{}) {
+	if _, ok := query[field]; !ok {
+		query[field] = condition
+		return
+	}
+	and, _ := query["$and"].([]bson.M)
+	query["$and"] = append(and, bson.M{field: condition})
+}
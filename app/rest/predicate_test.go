@@ -0,0 +1,182 @@
+package rest
+
+import (
+	"fmt"
+	"hlc/app/geo"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// conditions flattens the conditions on the field, set directly or in $and,
+// into sorted "operator value" strings, equality is "$eq value"
+func conditions(query bson.M, field string) []string {
+	values := make([]interface{}, 0)
+	if v, ok := query[field]; ok {
+		values = append(values, v)
+	}
+	and, _ := query["$and"].([]bson.M)
+	for _, condition := range and {
+		if v, ok := condition[field]; ok {
+			values = append(values, v)
+		}
+	}
+	flat := make([]string, 0)
+	for _, v := range values {
+		operators, ok := v.(bson.M)
+		if !ok {
+			flat = append(flat, fmt.Sprint("$eq ", v))
+			continue
+		}
+		for op, value := range operators {
+			flat = append(flat, fmt.Sprint(op, " ", value))
+		}
+	}
+	sort.Strings(flat)
+	return flat
+}
+
+const (
+	year1990 = 631152000 //1990-01-01
+	year1991 = 662688000 //1991-01-01
+)
+
+func TestFilterQueryCombinesPredicates(t *testing.T) {
+	a := &App{}
+	tests := []struct {
+		params string
+		field  string
+		want   []string
+	}{
+		{"birth_gt=1&birth_lt=2", "birth", []string{"$gt 1", "$lt 2"}},
+		{"birth_year=1990&birth_lt=650000000", "birth",
+			[]string{fmt.Sprint("$gte ", year1990), "$lt 650000000", fmt.Sprint("$lt ", year1991)}},
+		{"birth_year=1990&birth_gt=640000000", "birth",
+			[]string{"$gt 640000000", fmt.Sprint("$gte ", year1990), fmt.Sprint("$lt ", year1991)}},
+		{"city_eq=a&city_any=a,b", "city", []string{"$eq a", "$in [a b]"}},
+		{"sname_eq=a&sname_starts=b", "sname", []string{"$eq a", "$regex ^b"}},
+		{"email_domain=x.ru&email_lt=b&email_gt=a", "email", []string{"$gt a", "$lt b", "$regex (@x.ru)"}},
+		{"status_eq=a&status_neq=b", "status", []string{"$eq a", "$ne b"}},
+	}
+	for _, tt := range tests {
+		//the parameters come in the map order, so every case is run a few times
+		for i := 0; i < 10; i++ {
+			values, err := url.ParseQuery(tt.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			query, _, err := a.filterQuery(values)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.params, err)
+			}
+			if got := conditions(query, tt.field); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("%s: %s conditions %v, want %v", tt.params, tt.field, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestFilterQueryKeepsPremiumNow(t *testing.T) {
+	a := &App{now: 100}
+	values, _ := url.ParseQuery("premium_now=1&city_eq=a&city_null=0")
+	query, _, err := a.filterQuery(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"$eq a", "$exists true"}
+	if got := conditions(query, "city"); !reflect.DeepEqual(got, want) {
+		t.Errorf("city conditions %v, want %v", got, want)
+	}
+	if got := conditions(query, "premium.start"); !reflect.DeepEqual(got, []string{"$lt 100"}) {
+		t.Errorf("premium.start conditions %v", got)
+	}
+	if got := conditions(query, "premium.finish"); !reflect.DeepEqual(got, []string{"$gt 100"}) {
+		t.Errorf("premium.finish conditions %v", got)
+	}
+}
+
+func TestGroupQueryCombinesFilters(t *testing.T) {
+	a := &App{}
+	tests := []struct {
+		params string
+		field  string
+		want   []string
+	}{
+		{"birth=1990&birth_lt=650000000", "birth",
+			[]string{fmt.Sprint("$gte ", year1990), "$lt 650000000", fmt.Sprint("$lt ", year1991)}},
+		{"country=a&country_eq=b", "country", []string{"$eq a", "$eq b"}},
+		{"city=a&city_any=a,b", "city", []string{"$eq a", "$in [a b]"}},
+		{"sex=m&sex_eq=f", "sex", []string{"$eq f", "$eq m"}},
+		{"interests=a&interests_contains=b", "interests", []string{"$all [b]", "$elemMatch map[$eq:a]"}},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 10; i++ {
+			values, err := url.ParseQuery(tt.params + "&keys=sex&order=1&limit=1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			request, err := a.groupQuery(values)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.params, err)
+			}
+			if request.indexed {
+				t.Fatalf("%s: answered by the group index", tt.params)
+			}
+			if got := conditions(request.query, tt.field); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("%s: %s conditions %v, want %v", tt.params, tt.field, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestFilterWithinKm(t *testing.T) {
+	gazetteer, err := geo.LoadGazetteer(strings.NewReader("Москва,55.75,37.62\nСанкт-Петербург,59.94,30.31\nКазань,55.79,49.12\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _ := fixtureApp(t)
+
+	//the requests rejected before the database is queried
+	rejected := []string{"within_km=700", "within_km=700&city_any=Москва", "within_km=-1&city_eq=Москва", "within_km=x&city_eq=Москва"}
+	a.SetGazetteer(gazetteer)
+	for _, params := range rejected {
+		if w := serve(a, "GET", "/accounts/filter/?limit=5&"+params); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want %d", params, w.Code, http.StatusBadRequest)
+		}
+	}
+	a.SetGazetteer(nil)
+	if w := serve(a, "GET", "/accounts/filter/?limit=5&within_km=700&city_eq=Москва"); w.Code != http.StatusBadRequest {
+		t.Errorf("no gazetteer: status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	a.SetGazetteer(gazetteer)
+	tests := []struct {
+		params string
+		want   []string
+	}{
+		{"within_km=700&city_eq=Москва", []string{"$in [Москва Санкт-Петербург]"}},
+		{"within_km=1000&city_eq=Москва", []string{"$in [Казань Москва Санкт-Петербург]"}},
+		{"within_km=1&city_eq=Москва", []string{"$in [Москва]"}},
+		{"within_km=700&city_eq=Тверь", []string{"$in [Тверь]"}},
+	}
+	for _, tt := range tests {
+		values, err := url.ParseQuery(tt.params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		query, _, err := a.filterQuery(values)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.params, err)
+		}
+		cities := query["city"].(bson.M)["$in"].([]string)
+		sort.Strings(cities)
+		if got := conditions(query, "city"); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: city conditions %v, want %v", tt.params, got, tt.want)
+		}
+	}
+}
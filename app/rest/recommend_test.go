@@ -0,0 +1,161 @@
+package rest
+
+import (
+	"encoding/json"
+	"hlc/app/models"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// serve answers the request with the routes of the app
+func serve(a *App, method, url string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	a.router.ServeHTTP(w, httptest.NewRequest(method, url, nil))
+	return w
+}
+
+func TestRecommendResponseKeys(t *testing.T) {
+	a, accounts := fixtureApp(t)
+	a.SetNow(1546300800)
+	//the preferred ages and the phone are kept in the index but are not part of the response
+	for _, account := range accounts {
+		account.FName, account.SName, account.Phone = "Иван", "Иванов", "8(900)0000000"
+		account.AgeMin, account.AgeMax = 18, 90
+		a.recommendIndex.Put(account)
+	}
+
+	tests := []struct {
+		url  string
+		keys []string
+	}{
+		{"/accounts/1/recommend/?limit=20",
+			[]string{"birth", "email", "fname", "id", "premium", "sname", "status"}},
+		{"/accounts/1/recommend/?limit=20&explain=1",
+			[]string{"birth", "email", "explanation", "fname", "id", "premium", "sname", "status"}},
+	}
+	for _, tt := range tests {
+		w := serve(a, "GET", tt.url)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d", tt.url, w.Code)
+		}
+		var response struct {
+			Accounts []map[string]json.RawMessage `json:"accounts"`
+		}
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(response.Accounts) == 0 {
+			t.Fatalf("%s: no accounts", tt.url)
+		}
+		//the optional fields are omitted when empty, so the keys are
+		//collected over all the results
+		seen := make(map[string]bool)
+		for _, account := range response.Accounts {
+			for k := range account {
+				seen[k] = true
+			}
+		}
+		keys := make([]string, 0, len(seen))
+		for k := range seen {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		if !reflect.DeepEqual(keys, tt.keys) {
+			t.Errorf("%s: keys %v, want %v", tt.url, keys, tt.keys)
+		}
+	}
+}
+
+func TestRecommendExcludeLiked(t *testing.T) {
+	a := &App{}
+	a.initialize()
+	a.SetNow(1546300800)
+	a.recommendIndex.Put(models.Account{ID: 1, Sex: "m", Birth: 600000000, Interests: []string{"a"},
+		Likes: []models.Like{{ID: 2, TS: 1}}, Blocked: []int{3}})
+	for id := 2; id <= 4; id++ {
+		a.recommendIndex.Put(models.Account{ID: id, Sex: "f", Birth: 600000000, Status: "свободны", Interests: []string{"a"}})
+	}
+
+	tests := map[string][]int{
+		"limit=10":                 {2, 4},
+		"limit=10&exclude_liked=0": {2, 4},
+		"limit=10&exclude_liked=1": {4},
+	}
+	for params, want := range tests {
+		w := serve(a, "GET", "/accounts/1/recommend/?"+params)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d", params, w.Code)
+		}
+		got := models.Accounts{}
+		err := json.Unmarshal(w.Body.Bytes(), &got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := make([]int, 0)
+		for _, account := range got.Accounts {
+			ids = append(ids, account.ID)
+		}
+		sort.Ints(ids)
+		if !reflect.DeepEqual(ids, want) {
+			t.Errorf("%s: ids %v, want %v", params, ids, want)
+		}
+	}
+}
+
+func TestRecommendStrategy(t *testing.T) {
+	a, _ := fixtureApp(t)
+	a.SetNow(1546300800)
+
+	recommended := func(url string) []int {
+		w := serve(a, "GET", url)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d", url, w.Code)
+		}
+		got := models.Accounts{}
+		err := json.Unmarshal(w.Body.Bytes(), &got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := make([]int, 0)
+		for _, account := range got.Accounts {
+			ids = append(ids, account.ID)
+		}
+		return ids
+	}
+	want := func(name string) []int {
+		accounts, err := a.recommendIndex.Recommend(1, models.RecommendQuery{Limit: 10, Scorer: models.Scorers[name]})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := make([]int, 0)
+		for _, account := range accounts {
+			ids = append(ids, account.ID)
+		}
+		return ids
+	}
+
+	if got := recommended("/accounts/1/recommend/?limit=10"); !reflect.DeepEqual(got, want(models.DefaultScorer)) {
+		t.Errorf("default strategy: %v, want %v", got, want(models.DefaultScorer))
+	}
+	if got := recommended("/accounts/1/recommend/?limit=10&strategy=jaccard"); !reflect.DeepEqual(got, want("jaccard")) {
+		t.Errorf("jaccard strategy: %v, want %v", got, want("jaccard"))
+	}
+	if w := serve(a, "GET", "/accounts/1/recommend/?limit=10&strategy=random"); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown strategy: status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	//the configured strategy is used when the request has none
+	if err := a.SetScorer("random"); err == nil {
+		t.Error("unknown strategy configured")
+	}
+	if err := a.SetScorer("jaccard"); err != nil {
+		t.Fatal(err)
+	}
+	if got := recommended("/accounts/1/recommend/?limit=10"); !reflect.DeepEqual(got, want("jaccard")) {
+		t.Errorf("configured strategy: %v, want %v", got, want("jaccard"))
+	}
+}
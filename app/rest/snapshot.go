@@ -0,0 +1,95 @@
+package rest
+
+import (
+	"hlc/app/models"
+	"hlc/app/storage"
+	"log"
+)
+
+// WriteSnapshot saves all accounts to the snapshot file, the previous
+// snapshot is replaced only when the new one is complete
+func (a *App) WriteSnapshot(path string) error {
+	session := a.mongoSession.Copy()
+	defer session.Close()
+	collection := session.DB(dbName).C(accountsCollectionName)
+
+	a.mutations.Lock()
+	defer a.mutations.Unlock()
+
+	w, err := storage.CreateSnapshot(path, storage.SnapshotHeader{Now: a.now, WALSeq: a.walSeq})
+	if err != nil {
+		return err
+	}
+
+	iter := collection.Find(nil).Iter()
+	account := models.Account{}
+	for iter.Next(&account) {
+		err = w.Write(account)
+		if err != nil {
+			_ = iter.Close()
+			w.Abort()
+			return err
+		}
+		account = models.Account{}
+	}
+	err = iter.Close()
+	if err == nil {
+		err = a.likeIndex.Entries(w.WriteLikes)
+	}
+	if err == nil {
+		err = a.recommendIndex.Postings(w.WritePosting)
+	}
+	if err != nil {
+		w.Abort()
+		return err
+	}
+
+	err = w.Commit()
+	if err != nil {
+		return err
+	}
+	log.Println("[INFO] snapshot written to", path)
+	return nil
+}
+
+// LoadSnapshot inserts the accounts of the snapshot file and puts back the
+// indexes stored with them, the wal records up to the snapshot sequence
+// are skipped on replay
+func (a *App) LoadSnapshot(path string) error {
+	header, err := storage.ReadSnapshot(path, storage.SnapshotLoad{
+		Accounts: a.restoreAccounts,
+		Likes:    a.likeIndex.Restore,
+		Postings: a.recommendIndex.RestorePostings,
+	})
+	if err != nil {
+		return err
+	}
+	//the buckets hold the premium status at the snapshot time
+	if header.Now != a.now {
+		a.recommendIndex.SetNow(a.now)
+	}
+	a.walSeq = header.WALSeq
+	log.Println("[INFO] snapshot loaded from", path, "taken at now=", header.Now, "wal seq=", header.WALSeq)
+	return nil
+}
+
+// restoreAccounts inserts the accounts in bulk, unlike LoadData it does not
+// count the likes received, they are stored with the accounts
+func (a *App) restoreAccounts(accounts []models.Account) error {
+	session := a.mongoSession.Copy()
+	defer session.Close()
+	collection := session.DB(dbName).C(accountsCollectionName)
+
+	bulk := collection.Bulk()
+	bulk.Unordered()
+	docs := make([]interface{}, 0, len(accounts))
+	for i := range accounts {
+		docs = append(docs, &accounts[i])
+		a.loadedIDs[accounts[i].ID] = true
+		a.recommendIndex.Load(accounts[i])
+		a.groupIndex.Put(accounts[i])
+	}
+	bulk.Insert(docs...)
+	_, err := bulk.Run()
+	return err
+}
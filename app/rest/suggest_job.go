@@ -0,0 +1,43 @@
+package rest
+
+import (
+	"hlc/app/models"
+	"log"
+	"time"
+)
+
+// StartSuggestJob recomputes the most similar accounts of every account
+// in the background every interval and keeps up to size of them
+// in the suggest table read by /accounts/{id}/suggest/
+func (a *App) StartSuggestJob(interval time.Duration, size int) {
+	go func() {
+		for {
+			a.computeSuggestTable(size)
+			time.Sleep(interval)
+		}
+	}()
+}
+
+func (a *App) computeSuggestTable(size int) {
+	log.Println("[INFO] suggest table computing started")
+	start := time.Now()
+
+	acceptAll := func(models.Account) bool { return true }
+	for _, id := range a.recommendIndex.IDs() {
+		account, ok := a.recommendIndex.Get(id)
+		if !ok {
+			continue
+		}
+
+		//the version is read first, so that likes added meanwhile make the row stale
+		version := a.likeIndex.Version(id)
+		similar := a.similarAccounts(account, models.TimestampSimilarity{}, acceptAll)
+		truncated := len(similar) > size
+		if truncated {
+			similar = similar[:size]
+		}
+		a.suggestTable.Put(id, version, similar, truncated)
+	}
+
+	log.Println("[INFO] suggest table computed in", time.Since(start))
+}
@@ -0,0 +1,67 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"hlc/app/models"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// suggested returns the ids the suggest handler answers with
+func suggested(t *testing.T, a *App, url string) []int {
+	w := serve(a, "GET", url)
+	if w.Code != http.StatusOK {
+		t.Fatalf("%s: status %d", url, w.Code)
+	}
+	got := models.Accounts{}
+	err := json.Unmarshal(w.Body.Bytes(), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]int, 0)
+	for _, account := range got.Accounts {
+		ids = append(ids, account.ID)
+	}
+	return ids
+}
+
+// The suggestions read from the table are the live ones, also when the
+// table keeps only the head of the similar accounts.
+func TestSuggestTableMatchesLive(t *testing.T) {
+	live, accounts := fixtureApp(t)
+	for _, size := range []int{1, 3, 100} {
+		a, _ := fixtureApp(t)
+		a.computeSuggestTable(size)
+		for _, account := range accounts {
+			for _, limit := range []int{1, 5, 20} {
+				url := fmt.Sprintf("/accounts/%d/suggest/?limit=%d", account.ID, limit)
+				if got, want := suggested(t, a, url), suggested(t, live, url); !reflect.DeepEqual(got, want) {
+					t.Errorf("size %d %s = %v, want %v", size, url, got, want)
+				}
+			}
+		}
+	}
+}
+
+// The table is read first and its rows go stale when the account likes change.
+func TestSuggestTableRowGoesStale(t *testing.T) {
+	a, accounts := fixtureApp(t)
+	a.computeSuggestTable(100)
+	account := accounts[0]
+	url := fmt.Sprintf("/accounts/%d/suggest/?limit=20", account.ID)
+	want := suggested(t, a, url)
+
+	//an empty row at the current version answers the request
+	a.suggestTable.Put(account.ID, a.likeIndex.Version(account.ID), []int{}, false)
+	if got := suggested(t, a, url); len(got) != 0 {
+		t.Errorf("suggest from the empty row = %v", got)
+	}
+
+	//the likes make the row stale and the suggestions are computed live
+	a.likeIndex.Add(account.ID, []models.Like{})
+	if got := suggested(t, a, url); !reflect.DeepEqual(got, want) {
+		t.Errorf("suggest after the likes = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,108 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hlc/app/internal/golden"
+	"hlc/app/models"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// fixtureApp indexes the accounts of the models fixture
+func fixtureApp(t *testing.T) (*App, []models.Account) {
+	data, err := ioutil.ReadFile(filepath.Join("..", "models", "testdata", "accounts.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixture := models.Accounts{}
+	err = json.Unmarshal(data, &fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := &App{}
+	a.initialize()
+	for _, account := range fixture.Accounts {
+		a.recommendIndex.Put(account)
+		a.groupIndex.Put(account)
+	}
+	a.likeIndex.AddAccounts(fixture.Accounts)
+	return a, fixture.Accounts
+}
+
+// referenceSuggest is the suggest contract computed by brute force: the accounts
+// of the same sex sharing a likee, most similar first and ascending ids on equal
+// similarity, give their likees the account does not like, descending inside
+// every similar account, without repeats and the account itself, up to limit
+func referenceSuggest(accounts []models.Account, account models.Account, limit int) []int {
+	type similarAccount struct {
+		account    models.Account
+		similarity float64
+	}
+	vector := models.NewLikeVector(account.Likes)
+	similar := make([]similarAccount, 0)
+	for _, other := range accounts {
+		if other.ID == account.ID || other.Sex != account.Sex {
+			continue
+		}
+		if s := vector.Similarity(models.NewLikeVector(other.Likes)); s > 0 {
+			similar = append(similar, similarAccount{other, s})
+		}
+	}
+	sort.Slice(similar, func(i, j int) bool {
+		if similar[i].similarity != similar[j].similarity {
+			return similar[i].similarity > similar[j].similarity
+		}
+		return similar[i].account.ID < similar[j].account.ID
+	})
+
+	seen := map[int]bool{account.ID: true}
+	for _, like := range account.Likes {
+		seen[like.ID] = true
+	}
+	ids := make([]int, 0)
+	for _, s := range similar {
+		likes := make([]int, 0)
+		for _, like := range s.account.Likes {
+			likes = append(likes, like.ID)
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(likes)))
+		for _, id := range likes {
+			if len(ids) == limit {
+				return ids
+			}
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+func TestSuggestGolden(t *testing.T) {
+	a, accounts := fixtureApp(t)
+	acceptAll := func(models.Account) bool { return true }
+
+	var got bytes.Buffer
+	for _, account := range accounts {
+		vector := a.likeIndex.Vector(account.ID)
+		similar := a.similarAccounts(account, models.TimestampSimilarity{}, acceptAll)
+		for _, limit := range []int{1, 3, 20} {
+			ids := a.collectSuggestions(account.ID, vector, similar, limit, acceptAll)
+			if want := referenceSuggest(accounts, account, limit); !reflect.DeepEqual(ids, want) {
+				t.Errorf("suggest %d limit %d = %v, want %v", account.ID, limit, ids, want)
+			}
+			if limit == 20 {
+				fmt.Fprintln(&got, account.ID, ids)
+			}
+		}
+	}
+
+	golden.Check(t, "suggest.golden", got.Bytes())
+}
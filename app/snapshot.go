@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"log"
+)
+
+// snapshot writes the snapshot of the stored accounts. The stored accounts have
+// the mutations of the wal, the snapshot records its last sequence read before
+// the accounts, a mutation logged later is replayed over the snapshot on load.
+func snapshot(opts opts, args []string) {
+	flags := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	out := flags.String("out", "", "snapshot file")
+	_ = flags.Parse(args)
+	if *out == "" {
+		log.Fatal("[ERROR] -out is required")
+	}
+
+	app := newApp(opts, false)
+	if opts.walPath != "" {
+		err := app.SkipWAL(opts.walPath)
+		if err != nil {
+			log.Fatal("[ERROR] ", err)
+		}
+	}
+	//the indexes are written with the accounts
+	err := app.IndexCollection()
+	if err != nil {
+		log.Fatal("[ERROR] ", err)
+	}
+	err = app.WriteSnapshot(*out)
+	if err != nil {
+		log.Fatal("[ERROR] ", err)
+	}
+}
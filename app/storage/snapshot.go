@@ -0,0 +1,384 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"hlc/app/models"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Snapshot file layout, integers are little endian:
+//
+//	magic "HLCSNAP\x00", version uint32, now int64, wal sequence uint64
+//	sections, each of them chunks: length uint32, gob encoded slice
+//	  accounts: []models.Account
+//	  like index: []models.LikeIndexEntry
+//	  recommend buckets: []models.RecommendPosting
+//	  end of section: length 0
+//	crc32 (Castagnoli) of everything before it
+//
+// The indexes are stored next to the accounts, so they are not rebuilt on load.
+const (
+	snapshotMagic   = "HLCSNAP\x00"
+	SnapshotVersion = 3
+
+	snapshotChunkSize = 10000
+)
+
+// snapshot sections in the order they are written
+const (
+	sectionAccounts = iota
+	sectionLikes
+	sectionPostings
+	snapshotSections
+)
+
+var (
+	ErrSnapshotFormat   = errors.New("not a snapshot file")
+	ErrSnapshotVersion  = errors.New("unsupported snapshot version")
+	ErrSnapshotChecksum = errors.New("snapshot checksum mismatch")
+	ErrSnapshotSection  = errors.New("snapshot sections written out of order")
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+type SnapshotHeader struct {
+	Now    int    //current time of the dataset
+	WALSeq uint64 //sequence of the last write-ahead log record included
+}
+
+// SnapshotWriter writes a snapshot to a temporary file,
+// which replaces the snapshot file only on Commit
+type SnapshotWriter struct {
+	path   string
+	file   *os.File
+	buffer *bufio.Writer
+	out    io.Writer //buffer with the checksum computing
+	crc    hash.Hash32
+
+	section  int //section being written
+	accounts []models.Account
+	likes    []models.LikeIndexEntry
+	postings []models.RecommendPosting
+}
+
+func CreateSnapshot(path string, header SnapshotHeader) (*SnapshotWriter, error) {
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return nil, err
+	}
+
+	w := &SnapshotWriter{
+		path:   path,
+		file:   file,
+		buffer: bufio.NewWriter(file),
+		crc:    crc32.New(crcTable),
+	}
+	w.out = io.MultiWriter(w.buffer, w.crc)
+
+	var encoded bytes.Buffer
+	encoded.WriteString(snapshotMagic)
+	_ = binary.Write(&encoded, binary.LittleEndian, uint32(SnapshotVersion))
+	_ = binary.Write(&encoded, binary.LittleEndian, uint64(header.Now))
+	_ = binary.Write(&encoded, binary.LittleEndian, header.WALSeq)
+	if _, err = w.out.Write(encoded.Bytes()); err != nil {
+		w.Abort()
+		return nil, err
+	}
+	return w, nil
+}
+
+// Write adds the account to the accounts section
+func (w *SnapshotWriter) Write(account models.Account) error {
+	err := w.enter(sectionAccounts)
+	if err != nil {
+		return err
+	}
+	w.accounts = append(w.accounts, account)
+	if len(w.accounts) < snapshotChunkSize {
+		return nil
+	}
+	return w.flushChunk()
+}
+
+// WriteLikes adds the entry to the like index section, it ends the accounts
+func (w *SnapshotWriter) WriteLikes(entry models.LikeIndexEntry) error {
+	err := w.enter(sectionLikes)
+	if err != nil {
+		return err
+	}
+	w.likes = append(w.likes, entry)
+	if len(w.likes) < snapshotChunkSize {
+		return nil
+	}
+	return w.flushChunk()
+}
+
+// WritePosting adds the posting to the recommend buckets section, it ends the like index
+func (w *SnapshotWriter) WritePosting(posting models.RecommendPosting) error {
+	err := w.enter(sectionPostings)
+	if err != nil {
+		return err
+	}
+	w.postings = append(w.postings, posting)
+	if len(w.postings) < snapshotChunkSize {
+		return nil
+	}
+	return w.flushChunk()
+}
+
+// enter ends the sections before the section
+func (w *SnapshotWriter) enter(section int) error {
+	if section < w.section {
+		return ErrSnapshotSection
+	}
+	for w.section < section {
+		err := w.flushChunk()
+		if err != nil {
+			return err
+		}
+		err = binary.Write(w.out, binary.LittleEndian, uint32(0))
+		if err != nil {
+			return err
+		}
+		w.section++
+	}
+	return nil
+}
+
+func (w *SnapshotWriter) flushChunk() error {
+	var chunk interface{}
+	n := 0
+	switch w.section {
+	case sectionAccounts:
+		chunk, n = w.accounts, len(w.accounts)
+	case sectionLikes:
+		chunk, n = w.likes, len(w.likes)
+	case sectionPostings:
+		chunk, n = w.postings, len(w.postings)
+	}
+	if n == 0 {
+		return nil
+	}
+
+	var encoded bytes.Buffer
+	err := gob.NewEncoder(&encoded).Encode(chunk)
+	if err != nil {
+		return err
+	}
+	w.accounts = w.accounts[:0]
+	w.likes = w.likes[:0]
+	w.postings = w.postings[:0]
+
+	err = binary.Write(w.out, binary.LittleEndian, uint32(encoded.Len()))
+	if err != nil {
+		return err
+	}
+	_, err = w.out.Write(encoded.Bytes())
+	return err
+}
+
+// Commit finishes the snapshot and atomically renames it to its path
+func (w *SnapshotWriter) Commit() error {
+	err := w.enter(snapshotSections)
+	if err == nil {
+		err = binary.Write(w.buffer, binary.LittleEndian, w.crc.Sum32())
+	}
+	if err == nil {
+		err = w.buffer.Flush()
+	}
+	if err == nil {
+		err = w.file.Sync()
+	}
+	if err != nil {
+		w.Abort()
+		return err
+	}
+
+	err = w.file.Close()
+	if err != nil {
+		_ = os.Remove(w.file.Name())
+		return err
+	}
+	err = os.Rename(w.file.Name(), w.path)
+	if err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(w.path))
+}
+
+// Abort removes the temporary file leaving the previous snapshot untouched
+func (w *SnapshotWriter) Abort() {
+	_ = w.file.Close()
+	_ = os.Remove(w.file.Name())
+}
+
+// SnapshotLoad receives the sections of the snapshot chunk by chunk
+type SnapshotLoad struct {
+	Accounts func(accounts []models.Account) error
+	Likes    func(entries []models.LikeIndexEntry)
+	Postings func(postings []models.RecommendPosting)
+}
+
+// ReadSnapshot verifies the snapshot and passes its sections to load chunk by chunk.
+// The checksum is verified before the first chunk is passed, so a damaged
+// snapshot never loads partially.
+func ReadSnapshot(path string, load SnapshotLoad) (header SnapshotHeader, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return header, err
+	}
+	defer func() {
+		closeErr := file.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+
+	err = verifySnapshot(file)
+	if err != nil {
+		return header, err
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return header, err
+	}
+
+	return readChunks(bufio.NewReader(file), load)
+}
+
+func verifySnapshot(file *os.File) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() < int64(len(snapshotMagic))+20+4+4 {
+		return ErrSnapshotFormat
+	}
+
+	crc := crc32.New(crcTable)
+	_, err = io.CopyN(crc, bufio.NewReader(file), info.Size()-4)
+	if err != nil {
+		return err
+	}
+
+	var sum uint32
+	_, err = file.Seek(-4, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	err = binary.Read(file, binary.LittleEndian, &sum)
+	if err != nil {
+		return err
+	}
+	if sum != crc.Sum32() {
+		return ErrSnapshotChecksum
+	}
+	return nil
+}
+
+func readChunks(r io.Reader, load SnapshotLoad) (SnapshotHeader, error) {
+	header := SnapshotHeader{}
+	magic := make([]byte, len(snapshotMagic))
+	_, err := io.ReadFull(r, magic)
+	if err != nil || string(magic) != snapshotMagic {
+		return header, ErrSnapshotFormat
+	}
+
+	var version uint32
+	var now uint64
+	err = binary.Read(r, binary.LittleEndian, &version)
+	if err != nil {
+		return header, err
+	}
+	if version != SnapshotVersion {
+		return header, ErrSnapshotVersion
+	}
+	err = binary.Read(r, binary.LittleEndian, &now)
+	if err != nil {
+		return header, err
+	}
+	err = binary.Read(r, binary.LittleEndian, &header.WALSeq)
+	if err != nil {
+		return header, err
+	}
+	header.Now = int(now)
+
+	err = readSection(r, func(decoder *gob.Decoder) error {
+		accounts := make([]models.Account, 0, snapshotChunkSize)
+		err := decoder.Decode(&accounts)
+		if err != nil {
+			return err
+		}
+		return load.Accounts(accounts)
+	})
+	if err != nil {
+		return header, err
+	}
+	err = readSection(r, func(decoder *gob.Decoder) error {
+		entries := make([]models.LikeIndexEntry, 0, snapshotChunkSize)
+		err := decoder.Decode(&entries)
+		if err != nil {
+			return err
+		}
+		load.Likes(entries)
+		return nil
+	})
+	if err != nil {
+		return header, err
+	}
+	err = readSection(r, func(decoder *gob.Decoder) error {
+		postings := make([]models.RecommendPosting, 0)
+		err := decoder.Decode(&postings)
+		if err != nil {
+			return err
+		}
+		load.Postings(postings)
+		return nil
+	})
+	return header, err
+}
+
+// readSection passes the decoder of every chunk of the section to decode
+func readSection(r io.Reader, decode func(decoder *gob.Decoder) error) error {
+	for {
+		var length uint32
+		err := binary.Read(r, binary.LittleEndian, &length)
+		if err != nil {
+			return err
+		}
+		if length == 0 {
+			return nil
+		}
+
+		chunk := make([]byte, length)
+		_, err = io.ReadFull(r, chunk)
+		if err != nil {
+			return err
+		}
+		err = decode(gob.NewDecoder(bytes.NewReader(chunk)))
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	err = dir.Sync()
+	closeErr := dir.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
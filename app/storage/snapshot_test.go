@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"hlc/app/models"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestSnapshotRestoresIndexes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "snapshot")
+
+	accounts := make([]models.Account, 50)
+	for i := range accounts {
+		accounts[i] = models.Account{
+			ID:        i + 1,
+			Sex:       []string{"m", "f"}[i%2],
+			Status:    models.Statuses[i%len(models.Statuses)],
+			Interests: []string{"a", "b", "c"}[:i%3+1],
+			Likes:     []models.Like{{ID: (i+7)%50 + 1, TS: i}, {ID: (i+13)%50 + 1, TS: i * 2}},
+		}
+	}
+	likes := models.NewLikeIndex()
+	likes.AddAccounts(accounts)
+	recommend := models.NewRecommendIndex()
+	for _, account := range accounts {
+		recommend.Put(account)
+	}
+
+	w, err := CreateSnapshot(path, SnapshotHeader{Now: 100, WALSeq: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, account := range accounts {
+		if err = w.Write(account); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = likes.Entries(w.WriteLikes); err != nil {
+		t.Fatal(err)
+	}
+	if err = recommend.Postings(w.WritePosting); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Write(accounts[0]); err != ErrSnapshotSection {
+		t.Fatalf("account after the indexes: err = %v, want %v", err, ErrSnapshotSection)
+	}
+	if err = w.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := make([]models.Account, 0)
+	restoredLikes := models.NewLikeIndex()
+	restoredRecommend := models.NewRecommendIndex()
+	header, err := ReadSnapshot(path, SnapshotLoad{
+		Accounts: func(chunk []models.Account) error {
+			for _, account := range chunk {
+				loaded = append(loaded, account)
+				restoredRecommend.Load(account)
+			}
+			return nil
+		},
+		Likes:    restoredLikes.Restore,
+		Postings: restoredRecommend.RestorePostings,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header != (SnapshotHeader{Now: 100, WALSeq: 7}) {
+		t.Errorf("header = %+v", header)
+	}
+	if !reflect.DeepEqual(loaded, accounts) {
+		t.Errorf("accounts = %v, want %v", loaded, accounts)
+	}
+
+	for _, account := range accounts {
+		id := account.ID
+		if got, want := restoredLikes.Vector(id), likes.Vector(id); !reflect.DeepEqual(got, want) {
+			t.Errorf("vector of %d = %v, want %v", id, got, want)
+		}
+		if got, want := restoredLikes.Likers(id, 0, 0, 100), likes.Likers(id, 0, 0, 100); !reflect.DeepEqual(got, want) {
+			t.Errorf("likers of %d = %v, want %v", id, got, want)
+		}
+		if got, want := restoredLikes.Version(id), likes.Version(id); got != want {
+			t.Errorf("version of %d = %d, want %d", id, got, want)
+		}
+	}
+	if got, want := postings(t, restoredRecommend), postings(t, recommend); !reflect.DeepEqual(got, want) {
+		t.Errorf("postings = %v, want %v", got, want)
+	}
+}
+
+func TestReadSnapshotRejectsDamagedFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "snapshot")
+
+	w, err := CreateSnapshot(path, SnapshotHeader{Now: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Write(models.Account{ID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(snapshotMagic)+20] ^= 0xff
+	if err = ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := 0
+	_, err = ReadSnapshot(path, SnapshotLoad{
+		Accounts: func(chunk []models.Account) error {
+			loaded += len(chunk)
+			return nil
+		},
+		Likes:    func([]models.LikeIndexEntry) {},
+		Postings: func([]models.RecommendPosting) {},
+	})
+	if err != ErrSnapshotChecksum {
+		t.Errorf("err = %v, want %v", err, ErrSnapshotChecksum)
+	}
+	if loaded != 0 {
+		t.Errorf("%d accounts loaded from the damaged snapshot", loaded)
+	}
+}
+
+func postings(t *testing.T, x *models.RecommendIndex) []models.RecommendPosting {
+	all := make([]models.RecommendPosting, 0)
+	err := x.Postings(func(posting models.RecommendPosting) error {
+		all = append(all, posting)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		x, y := all[i], all[j]
+		if x.Sex != y.Sex {
+			return x.Sex < y.Sex
+		}
+		if x.Premium != y.Premium {
+			return y.Premium
+		}
+		if x.Status != y.Status {
+			return x.Status < y.Status
+		}
+		return x.Interest < y.Interest
+	})
+	return all
+}
@@ -0,0 +1,265 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// WAL record layout, integers are little endian:
+//
+//	length uint32 of the rest of the record without the crc
+//	crc32 (Castagnoli) uint32 of the rest of the record
+//	sequence uint64, type byte, payload
+const walRecordHeader = 8 + 1
+
+// MaxWALRecord bounds the length of a record, a longer length read back
+// comes from a damaged length prefix
+const MaxWALRecord = 16 << 20
+
+type RecordType byte
+
+const (
+	RecordAccountInsert RecordType = iota + 1
+	RecordAccountPatch
+	RecordLikes
+)
+
+// SyncPolicy tells when the appended records are fsynced
+type SyncPolicy int
+
+const (
+	SyncAlways   SyncPolicy = iota //after every record
+	SyncInterval                   //periodically in the background
+	SyncNever                      //left to the operating system
+)
+
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	switch s {
+	case "always":
+		return SyncAlways, nil
+	case "interval":
+		return SyncInterval, nil
+	case "never":
+		return SyncNever, nil
+	}
+	return 0, errors.New("unknown wal sync policy " + s)
+}
+
+var ErrWALRecordTooLarge = errors.New("wal record is too large")
+
+// WAL is an append-only log of the mutations
+type WAL struct {
+	mu     sync.Mutex
+	file   *os.File
+	policy SyncPolicy
+	seq    uint64
+	size   int64 //end of the last record
+	dirty  bool  //records were written after the last fsync
+	stop   chan struct{}
+}
+
+// OpenWAL opens the log for appending records after the sequence seq
+func OpenWAL(path string, seq uint64, policy SyncPolicy, interval time.Duration) (*WAL, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	w := &WAL{file: file, policy: policy, seq: seq, size: info.Size(), stop: make(chan struct{})}
+	if policy == SyncInterval {
+		go w.syncEvery(interval)
+	}
+	return w, nil
+}
+
+// Append writes the record and returns its sequence. A record failing to write
+// or to sync is cut off and its sequence is not used.
+func (w *WAL) Append(kind RecordType, payload []byte) (uint64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if walRecordHeader+len(payload) > MaxWALRecord {
+		return 0, ErrWALRecordTooLarge
+	}
+
+	seq := w.seq + 1
+	record := make([]byte, 8+walRecordHeader+len(payload))
+	binary.LittleEndian.PutUint32(record[0:], uint32(walRecordHeader+len(payload)))
+	binary.LittleEndian.PutUint64(record[8:], seq)
+	record[16] = byte(kind)
+	copy(record[17:], payload)
+	binary.LittleEndian.PutUint32(record[4:], crc32.Checksum(record[8:], crcTable))
+
+	_, err := w.file.Write(record)
+	if err != nil {
+		return 0, w.truncate(err)
+	}
+	w.dirty = true
+	if w.policy == SyncAlways {
+		err = w.sync()
+		if err != nil {
+			return 0, w.truncate(err)
+		}
+	}
+	w.seq = seq
+	w.size += int64(len(record))
+	return seq, nil
+}
+
+// truncate cuts a torn or unsynced record off the end of the log and returns err
+func (w *WAL) truncate(err error) error {
+	truncateErr := w.file.Truncate(w.size)
+	if truncateErr != nil {
+		log.Println("[ERROR] wal truncate: ", truncateErr)
+	}
+	return err
+}
+
+func (w *WAL) sync() error {
+	if !w.dirty {
+		return nil
+	}
+	//a failed fsync is retried by the next one
+	err := w.file.Sync()
+	if err != nil {
+		return err
+	}
+	w.dirty = false
+	return nil
+}
+
+func (w *WAL) syncEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.mu.Lock()
+			err := w.sync()
+			w.mu.Unlock()
+			if err != nil {
+				log.Println("[ERROR] wal sync: ", err)
+			}
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+func (w *WAL) Close() error {
+	close(w.stop)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	err := w.sync()
+	closeErr := w.file.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// ReplayWAL passes the records with a sequence after seq to apply and returns
+// the last sequence read. A torn or damaged tail left by a crash is cut off.
+func ReplayWAL(path string, seq uint64, apply func(seq uint64, kind RecordType, payload []byte) error) (uint64, error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if os.IsNotExist(err) {
+		return seq, nil
+	}
+	if err != nil {
+		return seq, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	r := bufio.NewReader(file)
+	var offset int64
+	for {
+		record, err := readRecord(r)
+		if err == io.EOF {
+			return seq, nil
+		}
+		if err != nil {
+			//everything after the last good record is dropped
+			return seq, file.Truncate(offset)
+		}
+		offset += int64(8 + len(record))
+
+		recordSeq := binary.LittleEndian.Uint64(record)
+		if recordSeq <= seq {
+			continue
+		}
+		err = apply(recordSeq, RecordType(record[8]), record[walRecordHeader:])
+		if err != nil {
+			return seq, err
+		}
+		seq = recordSeq
+	}
+}
+
+// LastWALSeq returns the sequence of the last good record. The log is only read,
+// a damaged tail may be a record which a running server is writing.
+func LastWALSeq(path string) (uint64, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	r := bufio.NewReader(file)
+	var seq uint64
+	for {
+		record, err := readRecord(r)
+		if err != nil {
+			return seq, nil
+		}
+		seq = binary.LittleEndian.Uint64(record)
+	}
+}
+
+var errWALRecord = errors.New("damaged wal record")
+
+// readRecord returns the checked record without its length and crc
+func readRecord(r io.Reader) ([]byte, error) {
+	header := make([]byte, 8)
+	n, err := io.ReadFull(r, header)
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil || n < 8 {
+		return nil, errWALRecord
+	}
+
+	length := binary.LittleEndian.Uint32(header)
+	if length < walRecordHeader || length > MaxWALRecord {
+		return nil, errWALRecord
+	}
+	record := make([]byte, length)
+	_, err = io.ReadFull(r, record)
+	if err != nil {
+		return nil, errWALRecord
+	}
+	if crc32.Checksum(record, crcTable) != binary.LittleEndian.Uint32(header[4:]) {
+		return nil, errWALRecord
+	}
+	return record, nil
+}
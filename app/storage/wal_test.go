@@ -0,0 +1,209 @@
+package storage
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReplayWALTruncatesDamagedTail(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "wal")
+
+	w, err := OpenWAL(path, 0, SyncAlways, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, payload := range []string{"a", "b", "c"} {
+		if _, err = w.Append(RecordLikes, []byte(payload)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	good := info.Size()
+
+	tails := map[string][]byte{
+		"torn header":    {9, 0},
+		"torn record":    {20, 0, 0, 0, 1, 2, 3, 4, 5},
+		"bad crc":        {10, 0, 0, 0, 1, 2, 3, 4, 4, 0, 0, 0, 0, 0, 0, 0, 3, 'x'},
+		"huge length":    {0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0},
+		"too big length": lengthPrefix(MaxWALRecord + 1),
+	}
+	for name, tail := range tails {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = f.Write(tail); err != nil {
+			t.Fatal(err)
+		}
+		if err = f.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		payloads := ""
+		seq, err := ReplayWAL(path, 1, func(seq uint64, kind RecordType, payload []byte) error {
+			payloads += string(payload)
+			return nil
+		})
+		if err != nil || seq != 3 || payloads != "bc" {
+			t.Errorf("%s: replay = %d %q %v, want 3 \"bc\" <nil>", name, seq, payloads, err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != good {
+			t.Errorf("%s: size after replay = %d, want %d", name, info.Size(), good)
+		}
+	}
+}
+
+func TestLastWALSeqLeavesDamagedTail(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "wal")
+
+	if seq, err := LastWALSeq(path); err != nil || seq != 0 {
+		t.Errorf("missing wal = %d %v, want 0 <nil>", seq, err)
+	}
+
+	w, err := OpenWAL(path, 4, SyncAlways, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, payload := range []string{"a", "b"} {
+		if _, err = w.Append(RecordLikes, []byte(payload)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Write([]byte{20, 0, 0, 0, 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if seq, err := LastWALSeq(path); err != nil || seq != 6 {
+		t.Errorf("last seq = %d %v, want 6 <nil>", seq, err)
+	}
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Size() != before.Size() {
+		t.Errorf("size = %d, want %d", after.Size(), before.Size())
+	}
+}
+
+func TestAppendRejectsTooLargeRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, err := OpenWAL(filepath.Join(dir, "wal"), 0, SyncNever, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if _, err = w.Append(RecordLikes, make([]byte, MaxWALRecord)); err != ErrWALRecordTooLarge {
+		t.Errorf("append = %v, want %v", err, ErrWALRecordTooLarge)
+	}
+	if seq, err := w.Append(RecordLikes, []byte("a")); err != nil || seq != 1 {
+		t.Errorf("append after rejection = %d %v, want 1 <nil>", seq, err)
+	}
+}
+
+func TestAppendFailureKeepsSequence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "wal")
+
+	w, err := OpenWAL(path, 0, SyncAlways, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if _, err = w.Append(RecordLikes, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+
+	//a torn record is cut off the end of the log
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Write([]byte{20, 0, 0, 0, 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.truncate(io.ErrShortWrite); err != io.ErrShortWrite {
+		t.Errorf("truncate = %v, want %v", err, io.ErrShortWrite)
+	}
+
+	//a failed write does not use the sequence
+	file := w.file
+	w.file, err = os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = w.Append(RecordLikes, []byte("b")); err == nil {
+		t.Error("append to a read only file succeeded")
+	}
+	_ = w.file.Close()
+	w.file = file
+	if seq, err := w.Append(RecordLikes, []byte("c")); err != nil || seq != 2 {
+		t.Errorf("append after failure = %d %v, want 2 <nil>", seq, err)
+	}
+
+	payloads := ""
+	seq, err := ReplayWAL(path, 0, func(seq uint64, kind RecordType, payload []byte) error {
+		payloads += string(payload)
+		return nil
+	})
+	if err != nil || seq != 2 || payloads != "ac" {
+		t.Errorf("replay = %d %q %v, want 2 \"ac\" <nil>", seq, payloads, err)
+	}
+}
+
+func lengthPrefix(length uint32) []byte {
+	prefix := make([]byte, 8)
+	binary.LittleEndian.PutUint32(prefix, length)
+	return prefix
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"flag"
+	"hlc/app/loader"
+	"log"
+	"os"
+)
+
+// validate checks the data without loading it and prints the report,
+// it exits with 1 when there is a violation
+func validate(_ opts, args []string) {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	dataPath := flags.String("data", dataFilePath, "zip, tar.gz, directory, JSON or NDJSON file of the accounts, - reads stdin")
+	_ = flags.Parse(args)
+
+	validator, err := loader.ValidateSource(*dataPath)
+	if err != nil {
+		log.Fatal("[ERROR] ", err)
+	}
+	report := validator.Finish()
+	err = report.Print(os.Stdout)
+	if err != nil {
+		log.Fatal("[ERROR] ", err)
+	}
+	if report.Total() > 0 {
+		os.Exit(1)
+	}
+}